@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -30,32 +28,34 @@ func run(args []string) error {
 	src, _ := filepath.Abs(args[0])
 	dst, _ := filepath.Abs(args[1])
 
-	data, err := ioutil.ReadFile(src)
+	in, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("unable to read file %q: %w", src, err)
+		return fmt.Errorf("unable to open file %q: %w", src, err)
 	}
-	r := bytes.NewReader(data)
-	animated, format, err := deanimator.IsAnimated(r)
+	defer in.Close()
+
+	out, err := os.Create(dst)
 	if err != nil {
-		return fmt.Errorf("unable to determine animation state for %q: %w", src, err)
-	}
-	log.Printf("detected as %q", format)
-	if !animated {
-		log.Printf("%q is not animated", src)
+		return fmt.Errorf("unable to create file %q: %w", dst, err)
 	}
 
-	r = bytes.NewReader(data)
-	w := bytes.NewBuffer([]byte{})
-	_, err = deanimator.RenderFirstFrame(r, w)
-	if err != nil {
-		return fmt.Errorf("unable to render first frame from %q: %w", src, err)
+	res, err := deanimator.Deanimate(in, out)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+		os.Remove(dst)
 	}
-	err = ioutil.WriteFile(dst, w.Bytes(), 0644)
 	if err != nil {
-		return fmt.Errorf("unable to write file %q: %w", dst, err)
+		return fmt.Errorf("unable to deanimate %q: %w", src, err)
+	}
+
+	log.Printf("detected as %q", res.InputFormat)
+	if !res.Animated {
+		log.Printf("%q is not animated", src)
 	}
 
-	log.Printf("deanimated version written to %q", dst)
+	log.Printf("deanimated version (%s) written to %q", res.MIMEType, dst)
 
 	return nil
 }
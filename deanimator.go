@@ -5,37 +5,98 @@ package deanimator
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"image"
 	"io"
-	"sync"
-	"sync/atomic"
+	"time"
 )
 
 // ErrFormat indicates that decoding encountered an unknown format.
 var ErrFormat = errors.New("deanimator: unknown format")
 
-// A format holds an image format's name, magic header and how to decode it.
-type format struct {
-	name, magic      string
-	isAnimated       func(io.Reader) (bool, error)
-	renderFirstFrame func(io.Reader, io.Writer) error
+// Options configures a single call to IsAnimatedContext or RenderFirstFrameContext. A nil *Options
+// is valid and leaves every format at its defaults.
+type Options struct {
+	// Formats limits the call to the named formats, data of any other format is reported as
+	// ErrFormat. A nil or empty Formats allows every registered format.
+	Formats []string
+
+	// FormatOptions holds format specific options keyed by format name, for example a *gif.Options
+	// under "gif". Formats ignore entries they do not recognize.
+	FormatOptions map[string]interface{}
+
+	// Limits bounds the resources the call may use, nil is unlimited. Formats enforce them with the
+	// Check methods and LimitReader of Options.
+	Limits *Limits
+
+	// RecoverPanics makes the registry recover panics raised by formats while sniffing, detecting
+	// or rendering, and return them as an *InternalError instead of crashing the program. Output
+	// already written to w when a format panics is left as is.
+	RecoverPanics bool
+
+	// DetectionBudget caps the bytes Detect lets a format examine after sniffing, 0 is unlimited.
+	// A format that runs out of budget before finding its evidence is reported as Undetermined
+	// instead of failing. Detect does not read ahead of the budget, so a reader without a Peek
+	// method loses no more than the budget, or the bytes sniffing looked at if those are more.
+	DetectionBudget int64
+
+	// Poster picks the frame RenderFirstFrame, Deanimate and FirstFrame render. Strategies other
+	// than PosterFirst need a format implementing FrameWalker, and output a PNG. A still image is
+	// rendered as PosterFirst renders it.
+	Poster PosterStrategy
 }
 
-// Formats is the list of registered formats.
-var (
-	formatsMu     sync.Mutex
-	atomicFormats atomic.Value
-)
+// FormatOption returns the format specific options stored under name, or nil if there are none.
+func (o *Options) FormatOption(name string) interface{} {
+	if o == nil {
+		return nil
+	}
+	return o.FormatOptions[name]
+}
+
+// allows reports whether the options allow the named format.
+func (o *Options) allows(name string) bool {
+	if o == nil || len(o.Formats) == 0 {
+		return true
+	}
+	for _, n := range o.Formats {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
 
 // RegisterFormat allows consumers to create their own deanimation format support,the format
 // registration/handling code for deanimator closely follows that of the image package
 // in the std library. To create your own format handling, you can read more about its
 // magic string matching to determing the best way to structure your registration.
+//
+// Formats are registered to DefaultRegistry. Formats with more capabilities should implement Format
+// and use Register instead.
 func RegisterFormat(name, magic string, isAnimated func(io.Reader) (bool, error), renderFirstFrame func(io.Reader, io.Writer) error) {
-	formatsMu.Lock()
-	formats, _ := atomicFormats.Load().([]format)
-	atomicFormats.Store(append(formats, format{name, magic, isAnimated, renderFirstFrame}))
-	formatsMu.Unlock()
+	RegisterFormatContext(name, magic,
+		func(_ context.Context, r io.Reader, _ *Options) (bool, error) {
+			return isAnimated(r)
+		},
+		func(_ context.Context, r io.Reader, w io.Writer, _ *Options) error {
+			return renderFirstFrame(r, w)
+		},
+	)
+}
+
+// RegisterFormatContext is like RegisterFormat, but the format functions are given the context and
+// options of each call. Formats should return ctx.Err() if the context is done while they are
+// still reading.
+func RegisterFormatContext(name, magic string, isAnimated func(context.Context, io.Reader, *Options) (bool, error), renderFirstFrame func(context.Context, io.Reader, io.Writer, *Options) error) {
+	Register(&funcFormat{name, magic, isAnimated, renderFirstFrame})
+}
+
+// Register adds a format to DefaultRegistry.
+func Register(f Format) {
+	DefaultRegistry.Register(f)
 }
 
 type reader interface {
@@ -43,14 +104,151 @@ type reader interface {
 	Peek(int) ([]byte, error)
 }
 
-// asReader converts an io.Reader to a reader.
+// asReader converts an io.Reader to a reader. Readers that can Seek remain able to.
 func asReader(r io.Reader) reader {
 	if rr, ok := r.(reader); ok {
 		return rr
 	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return &bufferedSeeker{bufio.NewReader(rs), rs}
+	}
 	return bufio.NewReader(r)
 }
 
+// bufferedSeeker buffers an io.ReadSeeker like bufio.Reader, and seeks relative to the bytes read
+// from the buffer.
+type bufferedSeeker struct {
+	*bufio.Reader
+	rs io.ReadSeeker
+}
+
+func (b *bufferedSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		offset -= int64(b.Buffered())
+	}
+	pos, err := b.rs.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	b.Reset(b.rs)
+	return pos, nil
+}
+
+// Discard skips the next n bytes of r, and returns how many it skipped. Formats use it to pass over
+// data they ignore. If r implements io.Seeker it seeks over the bytes instead of reading them,
+// falling back to reading when seeking fails, as it does for pipes. If r ends before n bytes, it
+// returns io.EOF.
+func Discard(r io.Reader, n int64) (int64, error) {
+	if b, ok := r.(interface {
+		Buffered() int
+		Discard(int) (int, error)
+	}); ok && n <= int64(b.Buffered()) {
+		// cheaper than seeking
+		d, err := b.Discard(int(n))
+		return int64(d), err
+	}
+	if s, ok := r.(io.Seeker); ok {
+		if cur, err := s.Seek(0, io.SeekCurrent); err == nil {
+			end, err := s.Seek(0, io.SeekEnd)
+			if err != nil {
+				return 0, err
+			}
+			target := cur + n
+			if end < target {
+				target = end
+			}
+			if _, err := s.Seek(target, io.SeekStart); err != nil {
+				return 0, err
+			}
+			if target-cur < n {
+				return target - cur, io.EOF
+			}
+			return n, nil
+		}
+	}
+	return io.CopyN(io.Discard, r, n)
+}
+
+// asPeeker converts an io.Reader to a reader that can be peeked at without consuming it. Readers
+// that cannot Peek are peeked at by reading and seeking back.
+func asPeeker(r io.Reader) (reader, error) {
+	if rr, ok := r.(reader); ok {
+		return rr, nil
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		return &seekPeeker{rs, start}, nil
+	}
+	return nil, errNotPeekable
+}
+
+var errNotPeekable = fmt.Errorf("deanimator: reader can neither Peek nor Seek, wrap it with bufio.NewReader: %w", ErrUnsupported)
+
+// seekPeeker peeks at a reader by reading from it and seeking back to where it started.
+type seekPeeker struct {
+	io.ReadSeeker
+	start int64
+}
+
+func (p *seekPeeker) Peek(n int) ([]byte, error) {
+	b := make([]byte, n)
+	read, err := io.ReadFull(p.ReadSeeker, b)
+	if err == io.ErrUnexpectedEOF {
+		// match bufio.Reader, which reports io.EOF for a short peek
+		err = io.EOF
+	}
+	if _, serr := p.Seek(p.start, io.SeekStart); serr != nil {
+		return nil, serr
+	}
+	return b[:read], err
+}
+
+// exactReader is a reader that never reads ahead of what it is asked for: Peek reads just the
+// bytes it returns, and Read passes through to r once they are consumed.
+type exactReader struct {
+	r      io.Reader
+	peeked []byte
+}
+
+func (e *exactReader) Peek(n int) ([]byte, error) {
+	if n > len(e.peeked) {
+		b := make([]byte, n)
+		copy(b, e.peeked)
+		read, err := io.ReadFull(e.r, b[len(e.peeked):])
+		e.peeked = b[:len(e.peeked)+read]
+		if err == io.ErrUnexpectedEOF {
+			// match bufio.Reader, which reports io.EOF for a short peek
+			err = io.EOF
+		}
+		if err != nil {
+			return e.peeked, err
+		}
+	}
+	return e.peeked[:n], nil
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if len(e.peeked) > 0 {
+		n := copy(p, e.peeked)
+		e.peeked = e.peeked[n:]
+		return n, nil
+	}
+	return e.r.Read(p)
+}
+
+// Sniff returns the name of the registered format of the image in r without consuming any of it.
+// If no format matched, it will return ErrFormat, unless r ended while still matching the magic of
+// a format, then it returns a *NeedMoreDataError.
+//
+// r must either have a Peek method like bufio.Reader, or implement io.Seeker. Passing the same
+// bufio.Reader on to the other functions of this package loses no data.
+func Sniff(r io.Reader) (string, error) {
+	return DefaultRegistry.Sniff(r, nil)
+}
+
 // Match reports whether magic matches b. Magic may contain "?" wildcards.
 func match(magic string, b []byte) bool {
 	if len(magic) != len(b) {
@@ -64,30 +262,18 @@ func match(magic string, b []byte) bool {
 	return true
 }
 
-// Sniff determines the format of r's data.
-func sniff(r reader) format {
-	formats, _ := atomicFormats.Load().([]format)
-	for _, f := range formats {
-		b, err := r.Peek(len(f.magic))
-		if err == nil && match(f.magic, b) {
-			return f
-		}
-	}
-	return format{}
-}
-
 // IsAnimated returns whether the image data in the reader is a known format and is recognized
 // as having multiple animation frames. If a format is not matched, ErrFormat is returned, otherwise
 // a flag indicating whether it is animated and the format name are returned. This function only
 // consumes as much of the reader as is necessary to determine if something is animated.
 func IsAnimated(r io.Reader) (bool, string, error) {
-	rr := asReader(r)
-	f := sniff(rr)
-	if f.isAnimated == nil {
-		return false, "", ErrFormat
-	}
-	b, err := f.isAnimated(rr)
-	return b, f.name, err
+	return IsAnimatedContext(context.Background(), r, nil)
+}
+
+// IsAnimatedContext is like IsAnimated, but stops with ctx.Err() once ctx is done and passes opts to
+// the matched format.
+func IsAnimatedContext(ctx context.Context, r io.Reader, opts *Options) (bool, string, error) {
+	return DefaultRegistry.IsAnimated(ctx, r, opts)
 }
 
 // RenderFirstFrame renders the first frame of an animated image to the provided writer. It will read
@@ -95,13 +281,241 @@ func IsAnimated(r io.Reader) (bool, string, error) {
 // no format matched, it will return ErrFormat.
 //
 // Some implementations of RenderFirstFrame may change the encoding format of the first frame (for
-// example from GIF to PNG).
+// example from GIF to PNG), use RenderFirstFrameContext to learn the output format.
 func RenderFirstFrame(r io.Reader, w io.Writer) (string, error) {
-	rr := asReader(r)
-	f := sniff(rr)
-	if f.renderFirstFrame == nil {
-		return "", ErrFormat
+	res, err := RenderFirstFrameContext(context.Background(), r, w, nil)
+	if res == nil {
+		return "", err
+	}
+	return res.Format, err
+}
+
+// RenderFirstFrameContext is like RenderFirstFrame, but stops with ctx.Err() once ctx is done and
+// passes opts to the matched format. It returns a Result describing the output, which is non-nil
+// whenever a format matched, even if rendering then failed.
+func RenderFirstFrameContext(ctx context.Context, r io.Reader, w io.Writer, opts *Options) (*Result, error) {
+	return DefaultRegistry.RenderFirstFrame(ctx, r, w, opts)
+}
+
+// FirstFrame decodes the first frame of an image, animated or not, and returns it with the matching
+// format. If no format matched, it will return ErrFormat. Formats implementing FirstFrameDecoder
+// return their pixels directly, the output of other formats is decoded with image.Decode, which
+// needs a decoder for it to be registered with the image package.
+func FirstFrame(r io.Reader) (image.Image, string, error) {
+	return FirstFrameContext(context.Background(), r, nil)
+}
+
+// FirstFrameContext is like FirstFrame, but stops with ctx.Err() once ctx is done and passes opts to
+// the matched format.
+func FirstFrameContext(ctx context.Context, r io.Reader, opts *Options) (image.Image, string, error) {
+	return DefaultRegistry.FirstFrame(ctx, r, opts)
+}
+
+// Inspect describes an image without decoding its pixels: its canvas size, frames, delays, loop
+// count and alpha. If no format matched, it will return ErrFormat, and if the format does not
+// implement Inspector, an error wrapping ErrUnsupported.
+func Inspect(r io.Reader) (*Info, error) {
+	return InspectContext(context.Background(), r, nil)
+}
+
+// InspectContext is like Inspect, but stops with ctx.Err() once ctx is done and passes opts to the
+// matched format.
+func InspectContext(ctx context.Context, r io.Reader, opts *Options) (*Info, error) {
+	return DefaultRegistry.Inspect(ctx, r, opts)
+}
+
+// RenderFrame writes frame index of an animated image to w, composited onto the canvas the way
+// browsers display it, and returns the matching format. A still image only has frame 0. The gif, png
+// and webp formats write a PNG. If no format matched, it will return ErrFormat, and if the format
+// does not implement FrameRenderer, an error wrapping ErrUnsupported. An index past the last frame
+// returns an error wrapping ErrFrameIndex.
+func RenderFrame(r io.Reader, w io.Writer, index int) (string, error) {
+	return RenderFrameContext(context.Background(), r, w, index, nil)
+}
+
+// RenderFrameContext is like RenderFrame, but stops with ctx.Err() once ctx is done and passes opts
+// to the matched format.
+func RenderFrameContext(ctx context.Context, r io.Reader, w io.Writer, index int, opts *Options) (string, error) {
+	return DefaultRegistry.RenderFrame(ctx, r, w, index, opts)
+}
+
+// RenderFrameAt is like RenderFrame, but renders the frame displayed t into playing the animation,
+// as found by Info.FrameAt. It reads the image twice, so it keeps what it read in memory. If the
+// format does not implement both Inspector and FrameRenderer, it returns an error wrapping
+// ErrUnsupported.
+func RenderFrameAt(r io.Reader, w io.Writer, t time.Duration) (string, error) {
+	return RenderFrameAtContext(context.Background(), r, w, t, nil)
+}
+
+// RenderFrameAtContext is like RenderFrameAt, but stops with ctx.Err() once ctx is done and passes
+// opts to the matched format.
+func RenderFrameAtContext(ctx context.Context, r io.Reader, w io.Writer, t time.Duration, opts *Options) (string, error) {
+	return DefaultRegistry.RenderFrameAt(ctx, r, w, t, opts)
+}
+
+// Detect is a verified IsAnimated: it only reports an image as animated when more than one real
+// frame exists, so single frame "animations" are reported as still images. The returned Detection
+// explains the evidence. If no format matched, it will return ErrFormat.
+func Detect(r io.Reader) (*Detection, error) {
+	return DetectContext(context.Background(), r, nil)
+}
+
+// DetectContext is like Detect, but stops with ctx.Err() once ctx is done and passes opts to the
+// matched format. If opts has a DetectionBudget the Detection may be Undetermined.
+func DetectContext(ctx context.Context, r io.Reader, opts *Options) (*Detection, error) {
+	return DefaultRegistry.Detect(ctx, r, opts)
+}
+
+// State is the outcome of Detect.
+type State int
+
+const (
+	// Undetermined means the evidence was not found within the DetectionBudget of the call.
+	Undetermined State = iota
+	// Static means the image has a single frame.
+	Static
+	// Animated means the image has more than one frame.
+	Animated
+)
+
+func (s State) String() string {
+	switch s {
+	case Undetermined:
+		return "undetermined"
+	case Static:
+		return "static"
+	case Animated:
+		return "animated"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// DetectAt is like Detect, but reads the image of size bytes from ra at offsets, for example from an
+// HTTPRangeReaderAt. Formats whose detectors implement SkippingDetector, like gif, png and webp,
+// jump over the data they skip, such as PNG chunk data, RIFF chunks and GIF sub-blocks, so it is
+// never read.
+func DetectAt(ra io.ReaderAt, size int64) (*Detection, error) {
+	return DetectAtContext(context.Background(), ra, size, nil)
+}
+
+// DetectAtContext is like DetectAt, but stops with ctx.Err() once ctx is done and passes opts to the
+// matched format.
+func DetectAtContext(ctx context.Context, ra io.ReaderAt, size int64, opts *Options) (*Detection, error) {
+	return DefaultRegistry.DetectAt(ctx, ra, size, opts)
+}
+
+// IsAnimatedAt reports whether DetectAt finds the image of size bytes in ra Animated, and the
+// matching format. Unlike IsAnimated, images declaring an animation of a single frame are not
+// animated.
+func IsAnimatedAt(ra io.ReaderAt, size int64) (bool, string, error) {
+	d, err := DetectAt(ra, size)
+	if err != nil {
+		return false, "", err
+	}
+	return d.State == Animated, d.Format, nil
+}
+
+// Detection is the result of Detect.
+type Detection struct {
+	// Format is the name of the registered format that matched the input.
+	Format string
+
+	// State reports whether the image has more than one frame, or that this could not be told
+	// within the DetectionBudget, or from the data written to a Detector so far.
+	State State
+
+	// NeedBytes is, for a detection left Undetermined by the DetectionBudget, how many more bytes
+	// than the budget the format needed to read its next piece of data, which may still not decide
+	// the detection. Formats implementing IncrementalVerifier report it exactly, for others it is an
+	// estimate. It is at least 1.
+	NeedBytes int64
+
+	// Reason is a human readable explanation of the evidence, for example
+	// "acTL declares 20 frames and a second fcTL follows".
+	Reason string
+}
+
+// Animated reports whether the detection is Animated.
+func (d *Detection) Animated() bool {
+	return d.State == Animated
+}
+
+// Result describes the output of rendering a frame.
+type Result struct {
+	// Format is the name of the registered format that matched the input, for example "png".
+	Format string
+
+	// InputFormat is the specific format of the input. It is the same as Format unless the format
+	// covers several kinds of images, for example it is "apng" for an animated PNG.
+	InputFormat string
+
+	// OutputFormat is the format the frame was encoded in, for example "png" for a GIF input. It is
+	// empty if the format did not report it.
+	OutputFormat string
+
+	// MIMEType is the MIME type of the output, for example "image/png".
+	MIMEType string
+
+	// Width and Height are the canvas size of the output.
+	Width, Height int
+
+	// Frames is the number of frames of the input, if the format learned it while rendering.
+	// Otherwise it is 0.
+	Frames int
+
+	// BytesRead is the number of bytes read from the input reader. It may include buffered bytes
+	// past the end of the image data, and does not include bytes seeked over.
+	BytesRead int64
+
+	// Animated is set by Deanimate if the input was animated. When it is false the output is a copy
+	// of the input.
+	Animated bool
+}
+
+// Deanimate writes a still version of the image in r to w in a single pass over r: the first frame
+// if the image is animated, otherwise the image unchanged. Only the bytes read while detecting
+// animation are buffered, so r does not need to be read twice. If no format matched, it will
+// return ErrFormat.
+func Deanimate(r io.Reader, w io.Writer) (*Result, error) {
+	return DeanimateContext(context.Background(), r, w, nil)
+}
+
+// DeanimateContext is like Deanimate, but stops with ctx.Err() once ctx is done and passes opts to
+// the matched format. An image copied through unchanged is held to the MaxBytes limit of opts.
+func DeanimateContext(ctx context.Context, r io.Reader, w io.Writer, opts *Options) (*Result, error) {
+	return DefaultRegistry.Deanimate(ctx, r, w, opts)
+}
+
+// ContextReader returns a reader that reads from r until ctx is done, after which every read fails
+// with ctx.Err(). Formats can use it to hand the stream to code that is not context aware. If r
+// implements io.ByteReader, so does the returned reader.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	if br, ok := r.(io.ByteReader); ok {
+		return &contextByteReader{contextReader{ctx, r}, br}
+	}
+	return &contextReader{ctx, r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+type contextByteReader struct {
+	contextReader
+	br io.ByteReader
+}
+
+func (c *contextByteReader) ReadByte() (byte, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
 	}
-	err := f.renderFirstFrame(rr, w)
-	return f.name, err
+	return c.br.ReadByte()
 }
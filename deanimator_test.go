@@ -0,0 +1,829 @@
+package deanimator_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slackhq/deanimator"
+	_ "github.com/slackhq/deanimator/gif"
+	_ "github.com/slackhq/deanimator/png"
+	_ "github.com/slackhq/deanimator/webp"
+)
+
+// onlyReader hides any other interfaces of the reader, like a network stream.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestDeanimate(t *testing.T) {
+	for _, tc := range []struct {
+		file, golden   string
+		expectAnimated bool
+		expectMIMEType string
+		expectWidth    int
+		expectHeight   int
+	}{
+		{"animated.png", "animated_golden.png", true, "image/png", 100, 100},
+		{"animated.webp", "animated_golden.webp", true, "image/webp", 400, 400},
+		{"emoji-smile.png", "emoji-smile.png", false, "image/png", 128, 128},
+		{"house.webp", "house.webp", false, "image/webp", 1536, 1024},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden, err := ioutil.ReadFile("testdata/" + tc.golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := bytes.NewBuffer([]byte{})
+			res, err := deanimator.Deanimate(onlyReader{bytes.NewReader(data)}, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Animated != tc.expectAnimated {
+				t.Errorf("expected animated == %v, got %v", tc.expectAnimated, res.Animated)
+			}
+			if res.MIMEType != tc.expectMIMEType {
+				t.Errorf("expected MIME type %q, got %q", tc.expectMIMEType, res.MIMEType)
+			}
+			if res.Width != tc.expectWidth || res.Height != tc.expectHeight {
+				t.Errorf("expected %dx%d, got %dx%d", tc.expectWidth, tc.expectHeight, res.Width, res.Height)
+			}
+			if !bytes.Equal(w.Bytes(), golden) {
+				t.Errorf("output (%d bytes) does not match %q (%d bytes)", w.Len(), tc.golden, len(golden))
+			}
+		})
+	}
+}
+
+func TestDeanimateUnknownFormat(t *testing.T) {
+	w := bytes.NewBuffer([]byte{})
+	if _, err := deanimator.Deanimate(bytes.NewReader([]byte("not an image")), w); err != deanimator.ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
+
+func TestDeanimateLimits(t *testing.T) {
+	for _, file := range []string{"animated.png", "emoji-smile.png", "house.webp"} {
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// an image ending exactly at the limit is within it
+			opts := &deanimator.Options{Limits: &deanimator.Limits{MaxBytes: int64(len(data))}}
+			w := bytes.NewBuffer([]byte{})
+			if _, err := deanimator.DeanimateContext(context.Background(), bytes.NewReader(data), w, opts); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+
+			opts.Limits.MaxBytes = 100
+			w.Reset()
+			_, err = deanimator.DeanimateContext(context.Background(), bytes.NewReader(data), w, opts)
+			var lerr *deanimator.LimitError
+			if !errors.Is(err, deanimator.ErrLimitExceeded) || !errors.As(err, &lerr) || lerr.Limit != "MaxBytes" {
+				t.Errorf("expected MaxBytes to be exceeded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckPixels(t *testing.T) {
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxPixels: 1 << 20}}
+	for _, tc := range []struct {
+		width, height int
+		expectErr     bool
+	}{
+		{1 << 10, 1 << 10, false},
+		{1 << 10, 1<<10 + 1, true},
+		// the product of these overflows an int64
+		{0xf8303030, 0x8d303030, true},
+	} {
+		if err := opts.CheckPixels(tc.width, tc.height); (err != nil) != tc.expectErr {
+			t.Errorf("%dx%d: expected an error %v, got %v", tc.width, tc.height, tc.expectErr, err)
+		}
+	}
+}
+
+func TestFirstFrame(t *testing.T) {
+	for _, tc := range []struct {
+		file, expectFormat string
+		expectBounds       image.Rectangle
+	}{
+		{"bees.gif", "gif", image.Rect(0, 0, 300, 169)},
+		{"animated.png", "png", image.Rect(0, 0, 100, 100)},
+		{"emoji-smile.png", "png", image.Rect(0, 0, 128, 128)},
+		{"animated.webp", "webp", image.Rect(0, 0, 400, 400)},
+		{"house.webp", "webp", image.Rect(0, 0, 1536, 1024)},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, format, err := deanimator.FirstFrame(onlyReader{bytes.NewReader(data)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tc.expectFormat {
+				t.Errorf("expected format %q, got %q", tc.expectFormat, format)
+			}
+			if m.Bounds() != tc.expectBounds {
+				t.Errorf("expected bounds %v, got %v", tc.expectBounds, m.Bounds())
+			}
+		})
+	}
+}
+
+func TestRenderFrame(t *testing.T) {
+	for _, tc := range []struct {
+		file, expectFormat string
+		frames             int
+		expectBounds       image.Rectangle
+	}{
+		{"bees.gif", "gif", 87, image.Rect(0, 0, 300, 169)},
+		{"animated.png", "png", 20, image.Rect(0, 0, 100, 100)},
+		{"emoji-smile.png", "png", 1, image.Rect(0, 0, 128, 128)},
+		{"animated.webp", "webp", 12, image.Rect(0, 0, 400, 400)},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := bytes.NewBuffer([]byte{})
+			format, err := deanimator.RenderFrame(onlyReader{bytes.NewReader(data)}, w, tc.frames-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tc.expectFormat {
+				t.Errorf("expected format %q, got %q", tc.expectFormat, format)
+			}
+			m, err := png.Decode(w)
+			if err != nil {
+				t.Fatalf("expected a png, got %v", err)
+			}
+			if m.Bounds() != tc.expectBounds {
+				t.Errorf("expected bounds %v, got %v", tc.expectBounds, m.Bounds())
+			}
+
+			_, err = deanimator.RenderFrame(bytes.NewReader(data), io.Discard, tc.frames)
+			if !errors.Is(err, deanimator.ErrFrameIndex) {
+				t.Errorf("expected ErrFrameIndex past the last frame, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFrameAt(t *testing.T) {
+	ms := time.Millisecond
+	delays := []time.Duration{100 * ms, 0, 50 * ms} // the second frame is displayed for 100ms
+	for _, tc := range []struct {
+		info   deanimator.Info
+		t      time.Duration
+		expect int
+	}{
+		{deanimator.Info{Frames: 1, LoopCount: 1}, time.Second, 0},
+		{deanimator.Info{Frames: 3, Delays: delays}, -ms, 0},
+		{deanimator.Info{Frames: 3, Delays: delays}, 0, 0},
+		{deanimator.Info{Frames: 3, Delays: delays}, 99 * ms, 0},
+		{deanimator.Info{Frames: 3, Delays: delays}, 100 * ms, 1},
+		{deanimator.Info{Frames: 3, Delays: delays}, 199 * ms, 1},
+		{deanimator.Info{Frames: 3, Delays: delays}, 200 * ms, 2},
+		{deanimator.Info{Frames: 3, Delays: delays}, 250 * ms, 0},   // wraps around forever
+		{deanimator.Info{Frames: 3, Delays: delays}, 10150 * ms, 1}, // 40 loops later
+		{deanimator.Info{Frames: 3, LoopCount: 2, Delays: delays}, 350 * ms, 1},
+		{deanimator.Info{Frames: 3, LoopCount: 2, Delays: delays}, 500 * ms, 2}, // stays on the last frame
+	} {
+		if index := tc.info.FrameAt(tc.t); index != tc.expect {
+			t.Errorf("expected frame %d at %v of %+v, got %d", tc.expect, tc.t, tc.info, index)
+		}
+	}
+}
+
+func TestRenderFrameAt(t *testing.T) {
+	for _, file := range []string{"bees.gif", "animated.png", "emoji-smile.png", "animated.webp"} {
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := deanimator.Inspect(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			at := 2500 * time.Millisecond
+
+			w := bytes.NewBuffer([]byte{})
+			if _, err := deanimator.RenderFrameAt(onlyReader{bytes.NewReader(data)}, w, at); err != nil {
+				t.Fatal(err)
+			}
+			expect := bytes.NewBuffer([]byte{})
+			if _, err := deanimator.RenderFrame(bytes.NewReader(data), expect, info.FrameAt(at)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(w.Bytes(), expect.Bytes()) {
+				t.Errorf("expected frame %d", info.FrameAt(at))
+			}
+		})
+	}
+}
+
+func TestPoster(t *testing.T) {
+	transparent := color.NRGBA{}
+	black := color.NRGBA{A: 0xff}
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	palette := color.Palette{transparent, black, red, blue}
+	frame := func(left, right color.Color) *image.Paletted {
+		m := image.NewPaletted(image.Rect(0, 0, 4, 1), palette)
+		m.Set(0, 0, left)
+		m.Set(1, 0, left)
+		m.Set(2, 0, right)
+		m.Set(3, 0, right)
+		return m
+	}
+	// an animation fading in from transparent and black, showing mostly red and ending on blue
+	frames := []*image.Paletted{
+		frame(transparent, transparent),
+		frame(black, black),
+		frame(red, blue),
+		frame(red, red),
+		frame(red, red),
+		frame(red, red),
+		frame(red, red),
+		frame(blue, blue),
+	}
+	w := bytes.NewBuffer([]byte{})
+	err := gif.EncodeAll(w, &gif.GIF{Image: frames, Delay: make([]int, len(frames))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+
+	for _, tc := range []struct {
+		poster       deanimator.PosterStrategy
+		expect       *image.Paletted
+		expectFrames int
+	}{
+		{deanimator.PosterLast, frames[7], 8},
+		// the frames after the poster are not walked
+		{deanimator.PosterFirstNonEmpty, frames[2], 0},
+		{deanimator.PosterMostRepresentative, frames[3], 8},
+	} {
+		t.Run(tc.poster.String(), func(t *testing.T) {
+			opts := &deanimator.Options{Poster: tc.poster}
+			w := bytes.NewBuffer([]byte{})
+			res, err := deanimator.DeanimateContext(context.Background(), onlyReader{bytes.NewReader(data)}, w, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.OutputFormat != "png" || res.Width != 4 || res.Height != 1 || res.Frames != tc.expectFrames {
+				t.Errorf("expected a 4x1 png of %d frames, got %+v", tc.expectFrames, *res)
+			}
+			m, err := png.Decode(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m2, _, err := deanimator.FirstFrameContext(context.Background(), bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for x := 0; x < 4; x++ {
+				expect := color.NRGBAModel.Convert(tc.expect.At(x, 0))
+				if c := color.NRGBAModel.Convert(m.At(x, 0)); c != expect {
+					t.Errorf("expected %v at %d, got %v", expect, x, c)
+				}
+				if c := color.NRGBAModel.Convert(m2.At(x, 0)); c != expect {
+					t.Errorf("expected FirstFrame to pick %v at %d, got %v", expect, x, c)
+				}
+			}
+		})
+	}
+
+	// every frame is empty
+	w.Reset()
+	err = gif.EncodeAll(w, &gif.GIF{Image: frames[:2], Delay: []int{0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &deanimator.Options{Poster: deanimator.PosterFirstNonEmpty}
+	m, _, err := deanimator.FirstFrameContext(context.Background(), bytes.NewReader(w.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.NRGBAModel.Convert(m.At(0, 0)); c != transparent {
+		t.Errorf("expected the first frame if every frame is empty, got %v", c)
+	}
+}
+
+func TestPosterStill(t *testing.T) {
+	stillPNG, err := ioutil.ReadFile("testdata/emoji-smile.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stillWebP, err := ioutil.ReadFile("testdata/house.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bytes.NewBuffer([]byte{})
+	err = gif.Encode(w, image.NewPaletted(image.Rect(0, 0, 4, 1), color.Palette{color.Black}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stillGIF := w.Bytes()
+
+	// every strategy treats a still image as PosterFirst does
+	for _, poster := range []deanimator.PosterStrategy{
+		deanimator.PosterFirst,
+		deanimator.PosterLast,
+		deanimator.PosterFirstNonEmpty,
+		deanimator.PosterMostRepresentative,
+	} {
+		opts := &deanimator.Options{Poster: poster}
+		for name, data := range map[string][]byte{"png": stillPNG, "webp": stillWebP} {
+			_, err := deanimator.RenderFirstFrameContext(context.Background(), bytes.NewReader(data), io.Discard, opts)
+			if err != deanimator.ErrNotAnimated {
+				t.Errorf("%v: expected ErrNotAnimated for a still %s, got %v", poster, name, err)
+			}
+		}
+		res, err := deanimator.RenderFirstFrameContext(context.Background(), bytes.NewReader(stillGIF), io.Discard, opts)
+		if err != nil {
+			t.Errorf("%v: expected a still gif to be rendered, got %v", poster, err)
+		} else if res.Width != 4 || res.Height != 1 {
+			t.Errorf("%v: expected a 4x1 image, got %+v", poster, *res)
+		}
+	}
+}
+
+func TestPosterInputFormat(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/animated.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, poster := range []deanimator.PosterStrategy{
+		deanimator.PosterFirst,
+		deanimator.PosterLast,
+		deanimator.PosterFirstNonEmpty,
+		deanimator.PosterMostRepresentative,
+	} {
+		opts := &deanimator.Options{Poster: poster}
+		res, err := deanimator.RenderFirstFrameContext(context.Background(), onlyReader{bytes.NewReader(data)}, io.Discard, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Format != "png" || res.InputFormat != "apng" {
+			t.Errorf("%v: expected an apng input, got %+v", poster, *res)
+		}
+	}
+}
+
+func TestPosterLimits(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/animated.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		limits      deanimator.Limits
+		expectLimit string
+	}{
+		{deanimator.Limits{MaxBytes: int64(len(data)), MaxChunkSize: int64(len(data))}, ""},
+		{deanimator.Limits{MaxBytes: 10000}, "MaxBytes"},
+	} {
+		t.Run(fmt.Sprintf("%+v", tc.limits), func(t *testing.T) {
+			opts := &deanimator.Options{Poster: deanimator.PosterMostRepresentative, Limits: &tc.limits}
+			_, err := deanimator.RenderFirstFrameContext(context.Background(), bytes.NewReader(data), io.Discard, opts)
+			var lerr *deanimator.LimitError
+			if tc.expectLimit == "" && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			} else if tc.expectLimit != "" && (!errors.As(err, &lerr) || lerr.Limit != tc.expectLimit) {
+				t.Errorf("expected %s to be exceeded, got %v", tc.expectLimit, err)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	for _, tc := range []struct {
+		file, expectFormat string
+		expectFrames       int
+	}{
+		{"bubbletea.gif", "gif", 125},
+		{"animated.png", "png", 20},
+		{"emoji-smile.png", "png", 1},
+		{"animated.webp", "webp", 12},
+		{"house.webp", "webp", 1},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := deanimator.Inspect(onlyReader{bytes.NewReader(data)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Format != tc.expectFormat || info.Frames != tc.expectFrames {
+				t.Errorf("expected a %s with %d frames, got %+v", tc.expectFormat, tc.expectFrames, *info)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		file   string
+		expect deanimator.State
+	}{
+		{"bees.gif", deanimator.Animated},
+		{"animated.png", deanimator.Animated},
+		{"emoji-smile.png", deanimator.Static},
+		{"animated.webp", deanimator.Animated},
+		{"house.webp", deanimator.Static},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := deanimator.Detect(onlyReader{bytes.NewReader(data)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.State != tc.expect || d.Reason == "" {
+				t.Errorf("expected %v with a reason, got %+v", tc.expect, *d)
+			}
+		})
+	}
+}
+
+func TestNeedMoreData(t *testing.T) {
+	for _, file := range []string{"bees.gif", "animated.png", "animated.webp"} {
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// retry as an upload would, waiting for the bytes each attempt asked for
+			n, attempts := int64(1), 0
+			for {
+				attempts++
+				_, err := deanimator.RenderFirstFrameContext(context.Background(), bytes.NewReader(data[:n]), ioutil.Discard, nil)
+				if err == nil {
+					break
+				}
+				var needErr *deanimator.NeedMoreDataError
+				if !errors.As(err, &needErr) || !errors.Is(err, deanimator.ErrTruncated) {
+					t.Fatalf("expected a *NeedMoreDataError with %d of %d bytes, got %v", n, len(data), err)
+				}
+				if needErr.Need < 1 || n+needErr.Need > int64(len(data)) {
+					t.Fatalf("asked for %d more bytes with %d of %d bytes", needErr.Need, n, len(data))
+				}
+				n += needErr.Need
+			}
+			t.Logf("rendered after %d attempts with %d of %d bytes", attempts, n, len(data))
+		})
+	}
+}
+
+func TestDetector(t *testing.T) {
+	for _, tc := range []struct {
+		file   string
+		expect deanimator.State
+	}{
+		{"bees.gif", deanimator.Animated},
+		{"animated.png", deanimator.Animated},
+		{"emoji-smile.png", deanimator.Static},
+		{"animated.webp", deanimator.Animated},
+		{"house.webp", deanimator.Static},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := deanimator.NewDetector()
+			if res, err := d.Result(); err != nil || res.State != deanimator.Undetermined {
+				t.Fatalf("expected an undetermined result before any data, got %+v, %v", res, err)
+			}
+			// push the data in pieces that split every structure somewhere
+			for len(data) > 0 {
+				n := 7
+				if n > len(data) {
+					n = len(data)
+				}
+				if _, err := d.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			res, err := d.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.State != tc.expect || res.Reason == "" || res.Format == "" {
+				t.Errorf("expected %v with a format and reason, got %+v", tc.expect, *res)
+			}
+		})
+	}
+
+	d := deanimator.NewDetector()
+	if _, err := d.Write([]byte("<html>")); err != deanimator.ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+	if _, err := d.Result(); err != deanimator.ErrFormat {
+		t.Errorf("expected the result to be ErrFormat, got %v", err)
+	}
+}
+
+// countingReaderAt counts the bytes read from it.
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestDetectAt(t *testing.T) {
+	for _, file := range []string{"bees.gif", "animated.png", "emoji-smile.png", "animated.webp", "house.webp"} {
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := deanimator.Detect(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ra := &countingReaderAt{r: bytes.NewReader(data)}
+			d, err := deanimator.DetectAt(ra, int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *d != *expected {
+				t.Errorf("expected %+v, got %+v", *expected, *d)
+			}
+			t.Logf("read %d of %d bytes", ra.n, len(data))
+		})
+	}
+
+	// the image data of the first frame is jumped over
+	data, err := ioutil.ReadFile("testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra := &countingReaderAt{r: bytes.NewReader(data)}
+	if animated, format, err := deanimator.IsAnimatedAt(ra, int64(len(data))); err != nil || !animated || format != "gif" {
+		t.Fatalf("expected an animated gif, got %v, %s, %v", animated, format, err)
+	}
+	if ra.n > 4096 {
+		t.Errorf("expected to read the structure of the first frame only, read %d bytes", ra.n)
+	}
+
+	if _, err := deanimator.DetectAt(bytes.NewReader(data), 5000); !errors.Is(err, deanimator.ErrNeedMoreData) {
+		t.Errorf("expected ErrNeedMoreData for a size within the first frame, got %v", err)
+	}
+}
+
+func TestHTTPRangeReaderAt(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name        string
+		handler     http.HandlerFunc
+		maxRequests int
+	}{
+		{"ranges", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "bees.gif", time.Time{}, bytes.NewReader(data))
+		}, 2},
+		{"no ranges", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		}, 1},
+		{"unknown size", func(w http.ResponseWriter, r *http.Request) {
+			var first, last int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last); err != nil || r.Method == http.MethodHead {
+				http.ServeContent(w, r, "bees.gif", time.Time{}, bytes.NewReader(data))
+				return
+			}
+			if last >= len(data) {
+				last = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", first, last))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[first : last+1])
+		}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+
+			ctx := context.Background()
+			h, err := deanimator.NewHTTPRangeReaderAt(ctx, srv.Client(), srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Size() != int64(len(data)) {
+				t.Fatalf("expected a size of %d, got %d", len(data), h.Size())
+			}
+			h.MinRange = 1024
+			d, err := deanimator.DetectAtContext(ctx, h, h.Size(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.State != deanimator.Animated {
+				t.Errorf("expected an animated detection, got %+v", *d)
+			}
+			if h.Requests() > tc.maxRequests {
+				t.Errorf("expected at most %d requests, made %d", tc.maxRequests, h.Requests())
+			}
+
+			// reads across fetched ranges are served from a new range
+			p := make([]byte, 3000)
+			if n, err := h.ReadAt(p, int64(len(data))-2000); n != 2000 || err != io.EOF || !bytes.Equal(p[:n], data[len(data)-2000:]) {
+				t.Errorf("expected the last 2000 bytes and io.EOF, got %d bytes, %v", n, err)
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := deanimator.NewHTTPRangeReaderAt(context.Background(), srv.Client(), srv.URL, nil); err == nil {
+		t.Error("expected an error for a missing resource")
+	}
+
+	// the whole resource sent by a server ignoring the range is bounded by MaxBytes
+	whole := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer whole.Close()
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxBytes: 1000}}
+	var limitErr *deanimator.LimitError
+	if _, err := deanimator.NewHTTPRangeReaderAt(context.Background(), whole.Client(), whole.URL, opts); !errors.As(err, &limitErr) {
+		t.Errorf("expected a *LimitError, got %v", err)
+	}
+
+	// a server sending less than the range asked for is not the end of the resource
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var first, last int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[first : first+(last-first)/2])
+	}))
+	defer short.Close()
+	h, err := deanimator.NewHTTPRangeReaderAt(context.Background(), short.Client(), short.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.MinRange = 0
+	p := make([]byte, 1000)
+	if n, err := h.ReadAt(p, 100000); err != io.ErrUnexpectedEOF || !bytes.Equal(p[:n], data[100000:100000+n]) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %d bytes, %v", n, err)
+	}
+}
+
+func TestDetectionBudget(t *testing.T) {
+	for _, tc := range []struct {
+		file   string
+		budget int64
+		expect deanimator.State
+	}{
+		{"bees.gif", 1000, deanimator.Undetermined},
+		{"bees.gif", 1 << 20, deanimator.Animated},
+		{"animated.png", 100, deanimator.Undetermined},
+		{"emoji-smile.png", 20, deanimator.Undetermined},
+		{"emoji-smile.png", 1 << 20, deanimator.Static},
+		{"house.webp", 10, deanimator.Undetermined},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := &deanimator.Options{DetectionBudget: tc.budget}
+			d, err := deanimator.DetectContext(context.Background(), onlyReader{bytes.NewReader(data)}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.State != tc.expect {
+				t.Fatalf("expected %v with a budget of %d, got %+v", tc.expect, tc.budget, *d)
+			}
+			if tc.expect == deanimator.Undetermined && d.NeedBytes <= 0 {
+				t.Errorf("expected an estimate of the bytes needed, got %+v", *d)
+			}
+			if tc.expect == deanimator.Undetermined {
+				// the estimate does not overstate what the format needed next
+				less := &deanimator.Options{DetectionBudget: tc.budget + d.NeedBytes - 1}
+				if d, err := deanimator.DetectContext(context.Background(), onlyReader{bytes.NewReader(data)}, less); err != nil || d.State != deanimator.Undetermined {
+					t.Errorf("expected a budget of %d to still be exhausted, got %+v, %v", less.DetectionBudget, d, err)
+				}
+			}
+			if tc.expect != deanimator.Undetermined && d.NeedBytes != 0 {
+				t.Errorf("expected no bytes needed, got %+v", *d)
+			}
+
+			// at most the budget, or the 15 bytes of the longest magic sniffing looks at, is taken
+			// from the stream
+			src := &countingReader{r: bytes.NewReader(data)}
+			if _, err := deanimator.DetectContext(context.Background(), src, opts); err != nil {
+				t.Fatal(err)
+			}
+			if src.n > tc.budget && src.n > 15 {
+				t.Errorf("expected at most %d bytes to be read, read %d", tc.budget, src.n)
+			}
+		})
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestDiscard(t *testing.T) {
+	data := []byte("0123456789")
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxBytes: 8}}
+	for name, reader := range map[string]func() io.Reader{
+		"Seeker":   func() io.Reader { return bytes.NewReader(data) },
+		"Reader":   func() io.Reader { return onlyReader{bytes.NewReader(data)} },
+		"Buffered": func() io.Reader { return bufio.NewReader(bytes.NewReader(data)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := reader()
+			if n, err := deanimator.Discard(r, 4); n != 4 || err != nil {
+				t.Fatalf("expected to discard 4 bytes, got %d, %v", n, err)
+			}
+			b := make([]byte, 2)
+			if _, err := io.ReadFull(r, b); err != nil || string(b) != "45" {
+				t.Fatalf("expected to read \"45\", got %q, %v", b, err)
+			}
+			if n, err := deanimator.Discard(r, 5); n != 4 || err != io.EOF {
+				t.Errorf("expected to discard 4 bytes and io.EOF, got %d, %v", n, err)
+			}
+
+			r = opts.LimitReader(reader())
+			if _, err := deanimator.Discard(r, 9); !errors.Is(err, deanimator.ErrLimitExceeded) {
+				t.Errorf("expected ErrLimitExceeded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLimitReaderByteReader(t *testing.T) {
+	data := []byte("0123456789")
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxBytes: 8}}
+	src := bytes.NewReader(data)
+	r := deanimator.ContextReader(context.Background(), opts.LimitReader(src))
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		t.Fatalf("expected the io.ByteReader of the source to be kept")
+	}
+	if _, ok := opts.LimitReader(src).(io.Seeker); !ok {
+		t.Errorf("expected the io.Seeker of the source to be kept")
+	}
+	for i := 0; i < 8; i++ {
+		if c, err := br.ReadByte(); err != nil || c != data[i] {
+			t.Fatalf("expected to read %q, got %q, %v", data[i], c, err)
+		}
+	}
+	if _, err := br.ReadByte(); !errors.Is(err, deanimator.ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+	if src.Len() != 1 {
+		t.Errorf("expected nothing to be read ahead, %d bytes are left", src.Len())
+	}
+
+	if _, ok := opts.LimitReader(onlyReader{src}).(io.ByteReader); ok {
+		t.Errorf("expected no io.ByteReader for a source without one")
+	}
+}
@@ -0,0 +1,194 @@
+package deanimator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Detector verifies the animation of an image written to it, for example as the pushes of an
+// upload arrive, without a goroutine pulling from an io.Reader. Only the bytes needed to sniff the
+// format and the piece of structure being parsed are buffered. Once the animation is decided,
+// further writes are accepted and ignored.
+//
+// Formats take part by implementing IncrementalVerifier, the gif, png and webp formats do.
+type Detector struct {
+	r    *Registry
+	opts *Options
+
+	buf []byte // written bytes not yet sniffed
+	n   int64
+	f   Format
+	inc IncrementalDetector
+
+	result *Detection
+	err    error
+}
+
+// NewDetector returns a Detector for the formats of DefaultRegistry.
+func NewDetector() *Detector {
+	return DefaultRegistry.NewDetector(nil)
+}
+
+// NewDetector returns a Detector for the formats of the registry that opts allows. It enforces the
+// MaxBytes limit of opts, the other limits are up to the formats. Formats recognized by a Sniffer
+// are sniffed on the bytes written so far.
+func (r *Registry) NewDetector(opts *Options) *Detector {
+	return &Detector{r: r, opts: opts}
+}
+
+// Write passes p to the detector. It returns an error if the data is not of a known format, is
+// malformed or exceeds a limit, and the same error for every write after that.
+func (d *Detector) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.result != nil {
+		return len(p), nil
+	}
+	n := len(p)
+	d.n += int64(n)
+	if err := check("MaxBytes", d.n, d.opts.limits().MaxBytes); err != nil {
+		d.err = err
+		return 0, err
+	}
+
+	if d.inc == nil {
+		d.buf = append(d.buf, p...)
+		if len(d.buf) == 0 {
+			return 0, nil
+		}
+		f, err := d.r.sniff(&peekBuffer{d.buf}, d.opts)
+		if errors.Is(err, ErrNeedMoreData) {
+			return n, nil
+		} else if err != nil {
+			d.err = err
+			return 0, err
+		}
+		v, ok := f.(IncrementalVerifier)
+		if !ok {
+			d.err = fmt.Errorf("deanimator: %s format cannot detect incrementally: %w", f.Name(), ErrUnsupported)
+			return 0, d.err
+		}
+		d.f = f
+		if err := guard(d.opts, f, func() error {
+			d.inc = v.NewIncrementalDetector(d.opts)
+			return nil
+		}); err != nil {
+			d.err = err
+			return 0, err
+		}
+		p, d.buf = d.buf, nil
+	}
+
+	var det *Detection
+	err := guard(d.opts, d.f, func() (err error) {
+		det, err = d.inc.Write(p)
+		return err
+	})
+	if err != nil {
+		d.err = err
+		return 0, err
+	}
+	if det != nil {
+		det.Format = d.f.Name()
+		d.result = det
+	}
+	return n, nil
+}
+
+// Result returns the Detection once the data written decides it. Until then it returns an
+// Undetermined Detection, and once a write failed, its error.
+func (d *Detector) Result() (*Detection, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.result != nil {
+		return d.result, nil
+	}
+	res := &Detection{State: Undetermined, Reason: "more data is needed to sniff the format"}
+	if d.f != nil {
+		res.Format = d.f.Name()
+		res.Reason = fmt.Sprintf("more %s data is needed", d.f.Name())
+	}
+	return res, nil
+}
+
+// sniffSize is how many bytes DetectAt reads to sniff the format, enough for magic strings and most
+// Sniffers.
+const sniffSize = 512
+
+// next consumes the bytes the format ignores next as if they were written and returns how many,
+// and how many bytes should be written next.
+func (d *Detector) next() (int64, int) {
+	if d.inc == nil {
+		if n := sniffSize - len(d.buf); n > 0 {
+			return 0, n
+		}
+		return 0, 1
+	}
+	if s, ok := d.inc.(SkippingDetector); ok {
+		skip, need := s.Skip(), s.Need()
+		if need < 1 {
+			need = 1
+		}
+		return skip, need
+	}
+	return 0, 4096
+}
+
+// DetectAt is like the package level DetectAtContext, but only considers the formats of the
+// registry.
+func (r *Registry) DetectAt(ctx context.Context, ra io.ReaderAt, size int64, opts *Options) (*Detection, error) {
+	d := r.NewDetector(opts)
+	var buf []byte
+	for off := int64(0); ; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res, err := d.Result()
+		if err != nil || res.State != Undetermined {
+			return res, err
+		}
+
+		skip, need := d.next()
+		off += skip
+		if off >= size {
+			return nil, fmt.Errorf("deanimator: image ended before its animation was decided: %w", NeedMoreData(off+int64(need)-size))
+		}
+		if rem := size - off; int64(need) > rem {
+			need = int(rem)
+		}
+		if cap(buf) < need {
+			buf = make([]byte, need)
+		}
+		n, err := ra.ReadAt(buf[:need], off)
+		if n < need {
+			if err == nil || err == io.EOF {
+				err = NeedMoreData(int64(need - n))
+			}
+			return nil, err
+		}
+		off += int64(n)
+		if _, err := d.Write(buf[:n]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// peekBuffer peeks at written bytes like a bufio.Reader would at a reader ending with them.
+type peekBuffer struct {
+	b []byte
+}
+
+func (p *peekBuffer) Read(b []byte) (int, error) {
+	return 0, errors.New("deanimator: peekBuffer is only peeked at")
+}
+
+func (p *peekBuffer) Peek(n int) ([]byte, error) {
+	if n > len(p.b) {
+		return p.b, io.EOF
+	}
+	return p.b[:n], nil
+}
@@ -0,0 +1,109 @@
+package deanimator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the formats, usually wrapped in a *ParseError. Use errors.Is to check for
+// them.
+var (
+	// ErrTruncated indicates the image data ended before the format could finish reading it.
+	// Formats return it as a *NeedMoreDataError.
+	ErrTruncated = errors.New("deanimator: truncated image data")
+
+	// ErrNeedMoreData indicates the image data ended early, but more of it, for example the rest of
+	// an upload still arriving, may let the call succeed when retried. It is returned as a
+	// *NeedMoreDataError, which is also ErrTruncated.
+	ErrNeedMoreData = errors.New("deanimator: need more data")
+
+	// ErrMalformed indicates the image data does not follow the structure of its format.
+	ErrMalformed = errors.New("deanimator: malformed image data")
+
+	// ErrNotAnimated indicates an operation that needs an animation was given a still image.
+	ErrNotAnimated = errors.New("deanimator: image is not animated")
+
+	// ErrUnsupported indicates the image uses a feature the format does not support, or that the
+	// format does not implement the requested capability.
+	ErrUnsupported = errors.New("deanimator: unsupported image feature")
+
+	// ErrFrameIndex indicates a frame was requested by an index the animation has no frame for.
+	ErrFrameIndex = errors.New("deanimator: frame index out of range")
+
+	// ErrInternal indicates a format panicked. It is only returned when Options.RecoverPanics is
+	// set, as an *InternalError.
+	ErrInternal = errors.New("deanimator: internal error")
+)
+
+// A ParseError describes where in the input a format failed to parse an image.
+type ParseError struct {
+	// Format is the name of the format reporting the error, for example "png".
+	Format string
+
+	// Offset is the byte offset in the input at which the problem was found. Formats made of chunks
+	// report the start of the chunk being read.
+	Offset int64
+
+	// Chunk is the name of the chunk or block being read, for example "IDAT" or
+	// "image descriptor". It is empty if the problem is not specific to one.
+	Chunk string
+
+	// Err is the underlying error, which usually wraps one of the errors of this package.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Chunk == "" {
+		return fmt.Sprintf("%s: at offset %d: %v", e.Format, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s: %s at offset %d: %v", e.Format, e.Chunk, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// A NeedMoreDataError reports image data that ended before the format could finish reading it.
+type NeedMoreDataError struct {
+	// Need is the least number of bytes past the end of the input the format needs to make
+	// progress, for example the rest of a chunk whose length it already read. It is 1 when the
+	// format does not know more.
+	Need int64
+}
+
+func (e *NeedMoreDataError) Error() string {
+	return fmt.Sprintf("deanimator: need at least %d more bytes", e.Need)
+}
+
+// Is reports whether target is ErrNeedMoreData or ErrTruncated.
+func (e *NeedMoreDataError) Is(target error) bool {
+	return target == ErrNeedMoreData || target == ErrTruncated
+}
+
+// NeedMoreData returns a *NeedMoreDataError for input that ended need bytes short, or 1 byte if need
+// is not positive because the format does not know.
+func NeedMoreData(need int64) error {
+	if need < 1 {
+		need = 1
+	}
+	return &NeedMoreDataError{Need: need}
+}
+
+// An InternalError reports a panic recovered from a format.
+type InternalError struct {
+	// Format is the name of the format that panicked.
+	Format string
+
+	// Value is the value the format panicked with.
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine, as formatted by debug.Stack.
+	Stack []byte
+}
+
+func (e *InternalError) Error() string {
+	return fmt.Sprintf("deanimator: %s format panicked: %v", e.Format, e.Value)
+}
+
+// Is reports whether target is ErrInternal.
+func (e *InternalError) Is(target error) bool {
+	return target == ErrInternal
+}
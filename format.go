@@ -0,0 +1,192 @@
+package deanimator
+
+import (
+	"context"
+	"image"
+	"io"
+	"time"
+)
+
+// A Format adds support for an image format to a Registry. Formats may additionally implement any
+// of the capability interfaces in this package (AnimationVerifier, FirstFrameDecoder, Inspector,
+// FrameRenderer, FrameWalker, InputFormatter, Validator and Sniffer), callers can look a format up
+// with Registry.Lookup and check for them before dispatching work.
+type Format interface {
+	// Name returns the name of the format, for example "gif".
+	Name() string
+
+	// Magic returns the magic header the format is detected with, it may contain "?" wildcards.
+	// Formats implementing Sniffer may return an empty string.
+	Magic() string
+
+	// IsAnimated reports whether the image in r has multiple animation frames, reading only as
+	// much of r as is necessary to tell.
+	IsAnimated(ctx context.Context, r io.Reader, opts *Options) (bool, error)
+
+	// RenderFirstFrame writes the first frame of the image in r to w as a still image. The returned
+	// Result reports what the format knows about the output, the Registry fills in Format and
+	// BytesRead.
+	RenderFirstFrame(ctx context.Context, r io.Reader, w io.Writer, opts *Options) (*Result, error)
+}
+
+// An AnimationVerifier is a Format that can verify an image has more than one real frame, rather
+// than trusting a flag or frame count declared in its header. Like IsAnimated, it reads only as
+// much of r as is necessary to tell.
+type AnimationVerifier interface {
+	VerifyAnimated(ctx context.Context, r io.Reader, opts *Options) (*Detection, error)
+}
+
+// A FirstFrameDecoder is a Format that can decode the first frame of an image to pixels directly,
+// without encoding it first. The first frame of a still image is the image itself.
+type FirstFrameDecoder interface {
+	FirstFrame(ctx context.Context, r io.Reader, opts *Options) (image.Image, error)
+}
+
+// An Inspector is a Format that can describe an image without rendering it.
+type Inspector interface {
+	Inspect(ctx context.Context, r io.Reader, opts *Options) (*Info, error)
+}
+
+// Info describes an image as reported by an Inspector.
+type Info struct {
+	// Format is the name of the format that inspected the image.
+	Format string
+
+	// Width and Height are the size of the canvas the frames are drawn on.
+	Width, Height int
+
+	// Frames is the number of animation frames, it is 1 for still images.
+	Frames int
+
+	// LoopCount is the number of times the animation plays, 0 means it loops forever. It is 1 for
+	// still images.
+	LoopCount int
+
+	// Delays are how long each frame is displayed, in order. It is nil for still images.
+	Delays []time.Duration
+
+	// Duration is how long the animation takes to play once, the sum of Delays.
+	Duration time.Duration
+
+	// HasAlpha reports whether the image may have transparent pixels.
+	HasAlpha bool
+}
+
+// Browsers display frames with a delay of minFrameDelay or less for clampedFrameDelay instead, so
+// animations saved with no delay do not spin as fast as possible.
+const (
+	minFrameDelay     = 10 * time.Millisecond
+	clampedFrameDelay = 100 * time.Millisecond
+)
+
+// FrameDelay returns how long browsers display a frame with the given delay.
+func FrameDelay(delay time.Duration) time.Duration {
+	if delay <= minFrameDelay {
+		return clampedFrameDelay
+	}
+	return delay
+}
+
+// FrameAt returns the index of the frame displayed t into playing the animation, with its delays
+// passed through FrameDelay. The animation wraps around until it has played LoopCount times, then
+// stays on its last frame. It returns 0 for still images and for negative t.
+func (i *Info) FrameAt(t time.Duration) int {
+	if len(i.Delays) == 0 || t < 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, delay := range i.Delays {
+		total += FrameDelay(delay)
+	}
+	if i.LoopCount > 0 && t/total >= time.Duration(i.LoopCount) {
+		return len(i.Delays) - 1
+	}
+	t %= total
+	for index, delay := range i.Delays {
+		if t < FrameDelay(delay) {
+			return index
+		}
+		t -= FrameDelay(delay)
+	}
+	return len(i.Delays) - 1
+}
+
+// A FrameRenderer is a Format that can render any frame of an animation, not just the first one.
+// Frames are indexed from 0.
+type FrameRenderer interface {
+	RenderFrame(ctx context.Context, r io.Reader, w io.Writer, index int, opts *Options) error
+}
+
+// A FrameWalker is a Format that can composite the frames of an animation one at a time, the way
+// browsers display them. It calls fn with the canvas after each frame is drawn, m is only valid
+// until fn returns. An error returned by fn stops the walk and is returned as is.
+type FrameWalker interface {
+	WalkFrames(ctx context.Context, r io.Reader, opts *Options, fn func(m image.Image) error) error
+}
+
+// An InputFormatter is a Format covering several kinds of images, for example PNG and APNG.
+// InputFormat names the kind of the image in r like Result.InputFormat does, reading only as much
+// of r as it needs to tell.
+type InputFormatter interface {
+	InputFormat(ctx context.Context, r io.Reader, opts *Options) (string, error)
+}
+
+// A Validator is a Format that can check an image is well formed without rendering it. Validate
+// reads all of r and returns a non-nil error describing the first problem it finds.
+type Validator interface {
+	Validate(ctx context.Context, r io.Reader, opts *Options) error
+}
+
+// An IncrementalVerifier is a Format that can verify animation like an AnimationVerifier from data
+// pushed to it in pieces, as a Detector does. Its detectors should also implement SkippingDetector.
+type IncrementalVerifier interface {
+	NewIncrementalDetector(opts *Options) IncrementalDetector
+}
+
+// An IncrementalDetector verifies the animation of a single image from its data, written to it in
+// order from the start. Write returns a Detection once the data seen decides it, and nil before.
+// It is not called again once it returned a Detection or an error.
+type IncrementalDetector interface {
+	Write(p []byte) (*Detection, error)
+}
+
+// A SkippingDetector is an IncrementalDetector that knows how much of the data it ignores, so callers
+// reading at offsets, like DetectAt, can jump over it.
+type SkippingDetector interface {
+	IncrementalDetector
+
+	// Skip consumes the bytes the detector ignores next as if they were written, and returns how
+	// many.
+	Skip() int64
+
+	// Need returns how many bytes the detector needs written next.
+	Need() int
+}
+
+// A Sniffer is a Format that recognizes its data with custom logic instead of a fixed magic header,
+// for example text based formats or formats with a variable offset header. Sniff is given a peek
+// function returning the first n bytes of the data without consuming them, and returns how many
+// bytes identified the format or 0 if the data is not recognized. The result is compared with the
+// non-wildcard length of the magic of other formats, so the most specific format wins. Peeks of
+// more than 4096 bytes may fail.
+type Sniffer interface {
+	Sniff(peek func(n int) ([]byte, error)) int
+}
+
+// funcFormat adapts the functions given to RegisterFormatContext to a Format.
+type funcFormat struct {
+	name, magic      string
+	isAnimated       func(context.Context, io.Reader, *Options) (bool, error)
+	renderFirstFrame func(context.Context, io.Reader, io.Writer, *Options) error
+}
+
+func (f *funcFormat) Name() string  { return f.name }
+func (f *funcFormat) Magic() string { return f.magic }
+
+func (f *funcFormat) IsAnimated(ctx context.Context, r io.Reader, opts *Options) (bool, error) {
+	return f.isAnimated(ctx, r, opts)
+}
+
+func (f *funcFormat) RenderFirstFrame(ctx context.Context, r io.Reader, w io.Writer, opts *Options) (*Result, error) {
+	return nil, f.renderFirstFrame(ctx, r, w, opts)
+}
@@ -0,0 +1,72 @@
+package gif
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/slackhq/deanimator/gif/parser"
+)
+
+// composite draws the first frame m onto the logical screen s. The screen starts out transparent,
+// or filled with its background color if fillBackground is set and the global color table has it.
+// A frame covering the whole screen is returned as is.
+func composite(m *image.Paletted, s *parser.Screen, fillBackground bool) image.Image {
+	screen := image.Rect(0, 0, s.Width, s.Height)
+	var bg color.Color = color.RGBA{}
+	if fillBackground && int(s.BackgroundIndex) < len(s.GlobalColorTable) {
+		bg = s.GlobalColorTable[s.BackgroundIndex]
+	}
+	if m.Rect == screen && !fillBackground {
+		return m
+	}
+
+	palette, bgIndex, ok := paletteIndex(m.Palette, bg)
+	if !ok {
+		// the palette is full, so fall back to a true color screen
+		c := image.NewNRGBA(screen)
+		draw.Draw(c, screen, image.NewUniform(bg), image.Point{}, draw.Src)
+		draw.Draw(c, m.Rect, m, m.Rect.Min, draw.Over)
+		return c
+	}
+
+	c := image.NewPaletted(screen, palette)
+	if bgIndex != 0 {
+		for i := range c.Pix {
+			c.Pix[i] = bgIndex
+		}
+	}
+	// transparent pixels of the frame show the screen below them
+	var transparent [256]bool
+	for i, p := range m.Palette {
+		_, _, _, a := p.RGBA()
+		transparent[i] = a == 0
+	}
+	r := m.Rect.Intersect(screen)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		src := m.Pix[m.PixOffset(r.Min.X, y):m.PixOffset(r.Max.X, y)]
+		dst := c.Pix[c.PixOffset(r.Min.X, y):c.PixOffset(r.Max.X, y)]
+		for x, i := range src {
+			if !transparent[i] {
+				dst[x] = i
+			}
+		}
+	}
+	return c
+}
+
+// paletteIndex returns p with c in it, and the index of c. It appends c to p if it is missing, and
+// fails if p is full.
+func paletteIndex(p color.Palette, c color.Color) (color.Palette, uint8, bool) {
+	r, g, b, a := c.RGBA()
+	for i, pc := range p {
+		pr, pg, pb, pa := pc.RGBA()
+		if pr == r && pg == g && pb == b && pa == a {
+			return p, uint8(i), true
+		}
+	}
+	if len(p) >= 256 {
+		return p, 0, false
+	}
+	return append(p[:len(p):len(p)], c), uint8(len(p)), true
+}
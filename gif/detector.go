@@ -0,0 +1,142 @@
+package gif
+
+import (
+	"fmt"
+
+	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/internal/incremental"
+)
+
+// Block and extension introducers, and fields of the GIF format used by detector.
+const (
+	sExtension       = 0x21
+	sImageDescriptor = 0x2C
+	sTrailer         = 0x3B
+
+	eText           = 0x01
+	eGraphicControl = 0xF9
+	eComment        = 0xFE
+	eApplication    = 0xFF
+
+	fColorTable         = 1 << 7
+	fColorTableBitsMask = 7
+)
+
+// NewIncrementalDetector returns a deanimator.SkippingDetector verifying the animation of a GIF
+// written to it like VerifyAnimated does. Color tables and sub-blocks are skipped as they are
+// written, only headers and descriptors are buffered. It decides as soon as the second image
+// descriptor starts. It enforces the MaxPixels and MaxFrames limits of opts.
+func NewIncrementalDetector(opts *deanimator.Options) deanimator.IncrementalDetector {
+	d := &detector{opts: opts}
+	d.p = incremental.New(13, d.header)
+	return d
+}
+
+type detector struct {
+	p                *incremental.Parser
+	opts             *deanimator.Options
+	result           *deanimator.Detection
+	globalColorTable bool
+	frames           int
+}
+
+func (d *detector) Write(b []byte) (*deanimator.Detection, error) {
+	if err := d.p.Write(b); err != nil {
+		return nil, err
+	}
+	return d.result, nil
+}
+
+func (d *detector) Skip() int64 { return d.p.TakeSkip() }
+func (d *detector) Need() int   { return d.p.Need() }
+
+func (d *detector) malformed(block, msg string) error {
+	err := fmt.Errorf("%s: %w", msg, deanimator.ErrMalformed)
+	return &deanimator.ParseError{Format: "gif", Offset: d.p.Offset(), Chunk: block, Err: err}
+}
+
+// colorTableSize returns the size in bytes of the color table described by fields.
+func colorTableSize(fields byte) int64 {
+	return 3 << (1 + uint(fields&fColorTableBitsMask))
+}
+
+func (d *detector) header(b []byte) error {
+	if vers := string(b[:6]); vers != "GIF87a" && vers != "GIF89a" {
+		return d.malformed("header", fmt.Sprintf("can't recognize format %q", vers))
+	}
+	width := int(b[6]) + int(b[7])<<8
+	height := int(b[8]) + int(b[9])<<8
+	if err := d.opts.CheckPixels(width, height); err != nil {
+		return &deanimator.ParseError{Format: "gif", Chunk: "header", Err: err}
+	}
+	if fields := b[10]; fields&fColorTable != 0 {
+		d.globalColorTable = true
+		d.p.Skip(colorTableSize(fields))
+	}
+	d.p.Read(1, d.block)
+	return nil
+}
+
+func (d *detector) block(b []byte) error {
+	switch b[0] {
+	case sExtension:
+		d.p.Read(1, d.extension)
+	case sImageDescriptor:
+		d.frames++
+		if err := d.opts.CheckFrames(d.frames); err != nil {
+			return &deanimator.ParseError{Format: "gif", Offset: d.p.Offset(), Chunk: "image descriptor", Err: err}
+		}
+		if d.frames == 2 {
+			d.result = &deanimator.Detection{Format: "gif", State: deanimator.Animated, Reason: "a second image descriptor follows the first"}
+			return nil
+		}
+		d.p.Read(9, d.imageDescriptor)
+	case sTrailer:
+		if d.frames == 0 {
+			return d.malformed("trailer", "missing image data")
+		}
+		d.result = &deanimator.Detection{Format: "gif", State: deanimator.Static, Reason: "only one image descriptor precedes the trailer"}
+	default:
+		return d.malformed("block", fmt.Sprintf("unknown block type 0x%.2x", b[0]))
+	}
+	return nil
+}
+
+func (d *detector) extension(b []byte) error {
+	switch b[0] {
+	case eText, eGraphicControl, eComment, eApplication:
+		// the fields of every extension are in its sub-blocks
+		d.p.Read(1, d.subBlock)
+		return nil
+	}
+	return d.malformed("extension", fmt.Sprintf("unknown extension 0x%.2x", b[0]))
+}
+
+func (d *detector) imageDescriptor(b []byte) error {
+	if fields := b[8]; fields&fColorTable != 0 {
+		d.p.Skip(colorTableSize(fields))
+	} else if !d.globalColorTable {
+		return d.malformed("image descriptor", "no color table")
+	}
+	d.p.Read(1, d.litWidth)
+	return nil
+}
+
+func (d *detector) litWidth(b []byte) error {
+	if b[0] < 2 || b[0] > 8 {
+		return d.malformed("image data", fmt.Sprintf("pixel size in decode out of range: %d", b[0]))
+	}
+	d.p.Read(1, d.subBlock)
+	return nil
+}
+
+// subBlock skips the data sub-block whose size is b, or reads the next block after the terminator.
+func (d *detector) subBlock(b []byte) error {
+	if b[0] == 0 {
+		d.p.Read(1, d.block)
+		return nil
+	}
+	d.p.Skip(int64(b[0]))
+	d.p.Read(1, d.subBlock)
+	return nil
+}
@@ -0,0 +1,115 @@
+package gif
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/gif/parser"
+	"github.com/slackhq/deanimator/internal/animation"
+)
+
+// RenderFrame writes frame index of the GIF in r to w as a PNG of the logical screen, composited
+// the way browsers display it: frames are drawn over the frames before them, which they dispose of
+// by their disposal method. The first frame has index 0.
+func RenderFrame(r io.Reader, w io.Writer, index int) error {
+	return RenderFrameContext(context.Background(), r, w, index, nil)
+}
+
+// RenderFrameContext is like RenderFrame, but stops with ctx.Err() once ctx is done. It always uses
+// the built-in parser, and enforces the limits of opts. An index past the last frame returns an
+// error wrapping deanimator.ErrFrameIndex.
+func RenderFrameContext(ctx context.Context, r io.Reader, w io.Writer, index int, opts *deanimator.Options) error {
+	p, err := newPlayer(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	if err := p.skipTo(index); err != nil {
+		return err
+	}
+	return png.Encode(w, p.canvas.Image())
+}
+
+// WalkFrames calls fn with each frame of the GIF in r, composited onto the logical screen like
+// RenderFrame does. m is only valid until fn returns, and an error returned by fn stops the walk and
+// is returned as is.
+func WalkFrames(r io.Reader, fn func(m image.Image) error) error {
+	return WalkFramesContext(context.Background(), r, nil, fn)
+}
+
+// WalkFramesContext is like WalkFrames, but stops with ctx.Err() once ctx is done. It enforces the
+// limits of opts like RenderFrameContext.
+func WalkFramesContext(ctx context.Context, r io.Reader, opts *deanimator.Options, fn func(m image.Image) error) error {
+	p, err := newPlayer(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := p.next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(p.canvas.Image()); err != nil {
+			return err
+		}
+	}
+}
+
+// player composites the frames of a GIF onto its logical screen one at a time.
+type player struct {
+	d      *parser.FrameDecoder
+	canvas *animation.Canvas
+	frames int // the number of frames drawn
+}
+
+func newPlayer(ctx context.Context, r io.Reader, opts *deanimator.Options) (*player, error) {
+	d, screen, err := parser.NewFrameDecoder(ctx, deanimator.ContextReader(ctx, r), opts)
+	if err != nil {
+		return nil, err
+	}
+	// browsers clear to transparent instead of the background color
+	var bg color.Color = color.Transparent
+	if options(opts).FillBackground && int(screen.BackgroundIndex) < len(screen.GlobalColorTable) {
+		bg = screen.GlobalColorTable[screen.BackgroundIndex]
+	}
+	return &player{d: d, canvas: animation.NewCanvas(screen.Width, screen.Height, bg)}, nil
+}
+
+// next draws the next frame onto the canvas and returns it. It returns io.EOF after the last frame.
+func (p *player) next() (*parser.Frame, error) {
+	frame, err := p.d.Next()
+	if err != nil {
+		return nil, err
+	}
+	dispose := animation.DisposeNone
+	switch frame.Disposal {
+	case parser.DisposalBackground:
+		dispose = animation.DisposeBackground
+	case parser.DisposalPrevious:
+		dispose = animation.DisposePrevious
+	}
+	// transparent pixels let the canvas show through
+	p.canvas.Draw(frame.Image, frame.Image.Rect.Min, true, dispose)
+	p.frames++
+	return frame, nil
+}
+
+// skipTo draws the frames up to frame index.
+func (p *player) skipTo(index int) error {
+	if index < 0 {
+		return fmt.Errorf("gif: frame %d: %w", index, deanimator.ErrFrameIndex)
+	}
+	for p.frames <= index {
+		if _, err := p.next(); err == io.EOF {
+			return fmt.Errorf("gif: frame %d of %d frames: %w", index, p.frames, deanimator.ErrFrameIndex)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,12 +2,14 @@
 package gif
 
 import (
+	"context"
+	"image"
 	"image/png"
 	"io"
+	"time"
 
 	"github.com/slackhq/deanimator"
 	"github.com/slackhq/deanimator/gif/parser"
-	"github.com/slackhq/deanimator/window"
 )
 
 //DecodeFunc lets you override the gif parser decode func. This implementation is our own
@@ -16,40 +18,189 @@ import (
 //https://github.com/golang/go/pull/46813
 var DecodeFunc = parser.Decode
 
+// Options holds the gif specific options of a single call, set it under "gif" in
+// deanimator.Options.FormatOptions.
+type Options struct {
+	// DecodeFunc overrides the package level DecodeFunc for a single call.
+	DecodeFunc func(io.Reader) (image.Image, error)
+
+	// FillBackground fills the logical screen around the first frame, and under its transparent
+	// pixels, with the background color of the global color table, as the GIF specification
+	// describes. By default they are left transparent, as browsers do.
+	FillBackground bool
+}
+
+func options(opts *deanimator.Options) *Options {
+	if o, ok := opts.FormatOption("gif").(*Options); ok && o != nil {
+		return o
+	}
+	return &Options{}
+}
+
 func RenderFirstFrame(r io.Reader, w io.Writer) error {
-	i, err := DecodeFunc(r)
+	_, err := RenderFirstFrameContext(context.Background(), r, w, nil)
+	return err
+}
+
+// RenderFirstFrameContext is like RenderFirstFrame, but stops with ctx.Err() once ctx is done.
+// The decode func is not context aware, so cancellation is checked on each read it makes. The
+// first frame is always encoded as a PNG. The built-in parser enforces the limits of opts, a
+// custom decode func only the MaxBytes limit.
+func RenderFirstFrameContext(ctx context.Context, r io.Reader, w io.Writer, opts *deanimator.Options) (*deanimator.Result, error) {
+	i, err := FirstFrameContext(ctx, r, opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	bounds := i.Bounds()
+	res := &deanimator.Result{
+		InputFormat:  "gif",
+		OutputFormat: "png",
+		MIMEType:     "image/png",
+		Width:        bounds.Dx(),
+		Height:       bounds.Dy(),
 	}
-	return png.Encode(w, i)
+	return res, png.Encode(w, i)
+}
+
+// FirstFrame decodes the first frame of a GIF. With the built-in parser it is drawn onto the
+// logical screen, which the frame may not cover in full: the rest of the screen is transparent,
+// unless Options.FillBackground is set.
+func FirstFrame(r io.Reader) (image.Image, error) {
+	return FirstFrameContext(context.Background(), r, nil)
 }
 
+// FirstFrameContext is like FirstFrame, but stops with ctx.Err() once ctx is done. The built-in
+// parser enforces the limits of opts, a custom decode func only the MaxBytes limit.
+func FirstFrameContext(ctx context.Context, r io.Reader, opts *deanimator.Options) (image.Image, error) {
+	decode := DecodeFunc
+	if o := options(opts); o.DecodeFunc != nil {
+		decode = o.DecodeFunc
+	}
+	// parser.Decode, also when wrapped, recognizes the source and enforces every limit of opts
+	src := &parser.Source{Reader: opts.LimitReader(deanimator.ContextReader(ctx, r)), Ctx: ctx, Opts: opts}
+	m, err := decode(src)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := m.(*image.Paletted); ok && src.Screen != nil {
+		return composite(p, src.Screen, options(opts).FillBackground), nil
+	}
+	return m, nil
+}
+
+// IsAnimated returns true if the GIF in r has more than one image descriptor. It walks the block
+// structure, skipping image data by its sub-block lengths, and reads up to the second image
+// descriptor, or to the trailer of a still image.
 func IsAnimated(r io.Reader) (bool, error) {
-	// TODO: read and check header to confirm a valid gif?
-
-	const windowSize = 3
-	count := 0
-
-	wr := window.NewReader(r, windowSize)
-	data := make([]byte, windowSize)
-	for {
-		_, err := wr.ReadWindow(data)
-		if err == io.EOF {
-			return false, nil
-		} else if err != nil {
-			return false, err
-		}
+	return IsAnimatedContext(context.Background(), r, nil)
+}
 
-		if data[0] == 0 && string(data[1]) == "!" && data[2] == 249 {
-			count += 1
-		}
+// IsAnimatedContext is like IsAnimated, but stops with ctx.Err() once ctx is done. It enforces the
+// limits of opts.
+func IsAnimatedContext(ctx context.Context, r io.Reader, opts *deanimator.Options) (bool, error) {
+	m, err := parser.InspectFrames(ctx, r, opts, 2)
+	if err != nil {
+		return false, err
+	}
+	return len(m.Delay) > 1, nil
+}
+
+// Validate reads the whole GIF in r, decoding every frame, and returns an error if it is not well
+// formed. It enforces the limits of opts.
+func Validate(ctx context.Context, r io.Reader, opts *deanimator.Options) error {
+	return parser.Validate(ctx, deanimator.ContextReader(ctx, r), opts)
+}
+
+// VerifyAnimated reports the GIF in r as animated if it has more than one image descriptor, reading
+// up to the second one without decoding image data. It enforces the limits of opts.
+func VerifyAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Detection, error) {
+	m, err := parser.InspectFrames(ctx, r, opts, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Delay) > 1 {
+		return &deanimator.Detection{Format: "gif", State: deanimator.Animated, Reason: "a second image descriptor follows the first"}, nil
+	}
+	return &deanimator.Detection{Format: "gif", State: deanimator.Static, Reason: "only one image descriptor precedes the trailer"}, nil
+}
 
-		if count > 1 {
-			return true, nil
+// Inspect reads the whole GIF in r without decoding its image data, and describes it. It enforces
+// the limits of opts.
+func Inspect(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Info, error) {
+	m, err := parser.Inspect(ctx, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	info := &deanimator.Info{
+		Format:    "gif",
+		Width:     m.Width,
+		Height:    m.Height,
+		Frames:    len(m.Delay),
+		LoopCount: 1,
+		HasAlpha:  m.Transparent,
+	}
+	if info.Frames > 1 {
+		// the loop count of a GIF counts the repeats after the first play
+		switch {
+		case m.LoopCount == 0:
+			info.LoopCount = 0
+		case m.LoopCount > 0:
+			info.LoopCount = m.LoopCount + 1
+		}
+		info.Delays = make([]time.Duration, len(m.Delay))
+		for i, delay := range m.Delay {
+			info.Delays[i] = time.Duration(delay) * 10 * time.Millisecond
+			info.Duration += info.Delays[i]
 		}
 	}
+	return info, nil
+}
+
+// Format implements deanimator.Format, deanimator.AnimationVerifier, deanimator.FirstFrameDecoder,
+// deanimator.FrameRenderer, deanimator.FrameWalker, deanimator.Inspector,
+// deanimator.IncrementalVerifier and deanimator.Validator for GIF images. It is registered to
+// deanimator.DefaultRegistry when this package is imported.
+type Format struct{}
+
+func (Format) Name() string  { return "gif" }
+func (Format) Magic() string { return "GIF8?a" }
+
+func (Format) IsAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (bool, error) {
+	return IsAnimatedContext(ctx, r, opts)
+}
+
+func (Format) RenderFirstFrame(ctx context.Context, r io.Reader, w io.Writer, opts *deanimator.Options) (*deanimator.Result, error) {
+	return RenderFirstFrameContext(ctx, r, w, opts)
+}
+
+func (Format) FirstFrame(ctx context.Context, r io.Reader, opts *deanimator.Options) (image.Image, error) {
+	return FirstFrameContext(ctx, r, opts)
+}
+
+func (Format) RenderFrame(ctx context.Context, r io.Reader, w io.Writer, index int, opts *deanimator.Options) error {
+	return RenderFrameContext(ctx, r, w, index, opts)
+}
+
+func (Format) WalkFrames(ctx context.Context, r io.Reader, opts *deanimator.Options, fn func(m image.Image) error) error {
+	return WalkFramesContext(ctx, r, opts, fn)
+}
+
+func (Format) VerifyAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Detection, error) {
+	return VerifyAnimated(ctx, r, opts)
+}
+
+func (Format) Inspect(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Info, error) {
+	return Inspect(ctx, r, opts)
+}
+
+func (Format) NewIncrementalDetector(opts *deanimator.Options) deanimator.IncrementalDetector {
+	return NewIncrementalDetector(opts)
+}
+
+func (Format) Validate(ctx context.Context, r io.Reader, opts *deanimator.Options) error {
+	return Validate(ctx, r, opts)
 }
 
 func init() {
-	deanimator.RegisterFormat("gif", "GIF8?a", IsAnimated, RenderFirstFrame)
+	deanimator.Register(Format{})
 }
@@ -2,12 +2,23 @@ package gif
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	gogif "image/gif"
+	"image/png"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/slackhq/deanimator"
 	"github.com/slackhq/deanimator/goldentest"
+	"github.com/slackhq/deanimator/internal/fuzztest"
 )
 
 func TestMain(m *testing.M) {
@@ -84,3 +95,582 @@ func TestRenderFirstFrame(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderFirstFrameScreen(t *testing.T) {
+	for _, test := range []struct {
+		file string
+	}{
+		{"undersized"}, // the first frame covers the top left of the screen
+		{"offset"},     // the first frame is offset within the screen, and has transparent pixels
+	} {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("../testdata/", test.file+".gif"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := bytes.NewBuffer([]byte{})
+			res, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Width != 32 || res.Height != 24 {
+				t.Errorf("expected the 32x24 logical screen, got %dx%d", res.Width, res.Height)
+			}
+
+			goldentest.Equals(t, test.file+"_golden.png", w.Bytes())
+		})
+	}
+}
+
+func TestFirstFrameBackground(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/offset.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	background := color.NRGBAModel.Convert(color.RGBA{0x2e, 0xb6, 0x7d, 0xff})
+	red := color.NRGBAModel.Convert(color.RGBA{0xe0, 0x1e, 0x5a, 0xff})
+
+	for _, tc := range []struct {
+		fill        bool
+		expectColor color.Color
+	}{
+		{false, color.NRGBA{}},
+		{true, background},
+	} {
+		opts := &deanimator.Options{FormatOptions: map[string]interface{}{"gif": &Options{FillBackground: tc.fill}}}
+		m, err := FirstFrameContext(context.Background(), bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Bounds() != image.Rect(0, 0, 32, 24) {
+			t.Errorf("expected the bounds of the logical screen, got %v", m.Bounds())
+		}
+		for _, p := range []image.Point{
+			{0, 0},   // outside of the frame
+			{10, 6},  // a transparent pixel of the frame
+			{31, 23}, // outside of the frame
+		} {
+			if c := color.NRGBAModel.Convert(m.At(p.X, p.Y)); c != tc.expectColor {
+				t.Errorf("fill %v: expected %v at %v, got %v", tc.fill, tc.expectColor, p, c)
+			}
+		}
+		if c := color.NRGBAModel.Convert(m.At(9, 6)); c != red {
+			t.Errorf("fill %v: expected the frame to be drawn, got %v", tc.fill, c)
+		}
+	}
+}
+
+func TestRenderFrame(t *testing.T) {
+	transparent := color.NRGBA{}
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	green := color.NRGBA{G: 0xff, A: 0xff}
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	palette := color.Palette{transparent, red, blue, green, white}
+	frame := func(x, width int, c color.Color) *image.Paletted {
+		m := image.NewPaletted(image.Rect(x, 0, x+width, 1), palette)
+		for i := x; i < x+width; i++ {
+			m.Set(i, 0, c)
+		}
+		return m
+	}
+	w := bytes.NewBuffer([]byte{})
+	err := gogif.EncodeAll(w, &gogif.GIF{
+		Image: []*image.Paletted{frame(0, 4, red), frame(0, 2, blue), frame(2, 1, green), frame(3, 1, white)},
+		Delay: []int{10, 10, 10, 10},
+		Disposal: []byte{
+			gogif.DisposalNone,
+			gogif.DisposalPrevious,   // restores red
+			gogif.DisposalBackground, // clears to transparent
+			gogif.DisposalNone,
+		},
+		Config: image.Config{ColorModel: palette, Width: 4, Height: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+
+	for index, expect := range [][]color.NRGBA{
+		{red, red, red, red},
+		{blue, blue, red, red},
+		{red, red, green, red},
+		{red, red, transparent, white},
+	} {
+		w := bytes.NewBuffer([]byte{})
+		if err := RenderFrame(bytes.NewReader(data), w, index); err != nil {
+			t.Fatal(err)
+		}
+		m, err := png.Decode(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x, c := range expect {
+			if got := color.NRGBAModel.Convert(m.At(x, 0)); got != c {
+				t.Errorf("frame %d: expected %v at %d, got %v", index, c, x, got)
+			}
+		}
+	}
+
+	if err := RenderFrame(bytes.NewReader(data), io.Discard, 4); !errors.Is(err, deanimator.ErrFrameIndex) {
+		t.Errorf("expected ErrFrameIndex past the last frame, got %v", err)
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := IsAnimatedContext(ctx, bytes.NewReader(data), nil); err != context.Canceled {
+		t.Errorf("expected IsAnimatedContext to return context.Canceled, got %v", err)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	if _, err := RenderFirstFrameContext(ctx, bytes.NewReader(data), w, nil); err == nil {
+		t.Errorf("expected RenderFirstFrameContext to fail, got nil")
+	}
+}
+
+func TestRenderFirstFrameContextDecodeFunc(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	opts := &deanimator.Options{
+		FormatOptions: map[string]interface{}{
+			"gif": &Options{
+				DecodeFunc: func(r io.Reader) (image.Image, error) {
+					called = true
+					return image.NewGray(image.Rect(0, 0, 1, 1)), nil
+				},
+			},
+		},
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	if _, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Errorf("expected the per call DecodeFunc to be used")
+	}
+
+	// the package level DecodeFunc defaults to the built-in parser, and can be called directly
+	if m, err := DecodeFunc(bytes.NewReader(data)); err != nil || m.Bounds() != image.Rect(0, 0, 300, 169) {
+		t.Fatalf("expected the first frame from the default DecodeFunc, got %v", err)
+	}
+
+	// a package level DecodeFunc wrapping the default keeps the limits of the built-in parser
+	called = false
+	saved := DecodeFunc
+	DecodeFunc = func(r io.Reader) (image.Image, error) {
+		called = true
+		return saved(r)
+	}
+	defer func() { DecodeFunc = saved }()
+	w.Reset()
+	if _, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Errorf("expected the package level DecodeFunc to be used")
+	}
+	limits := &deanimator.Options{Limits: &deanimator.Limits{MaxPixels: 100}}
+	_, err = RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, limits)
+	checkLimitError(t, err, "MaxPixels")
+}
+
+func TestValidate(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Validate(context.Background(), bytes.NewReader(data), nil); err != nil {
+		t.Errorf("expected bees.gif to be valid, got %v", err)
+	}
+	if err := Validate(context.Background(), bytes.NewReader(data[:len(data)-1]), nil); err == nil {
+		t.Errorf("expected a gif missing its trailer to be invalid")
+	}
+}
+
+func TestRenderFirstFrameErrors(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	err = RenderFirstFrame(bytes.NewReader(data[:1000]), w)
+	if !errors.Is(err, deanimator.ErrTruncated) {
+		t.Errorf("expected truncated error, got %v", err)
+	}
+	var perr *deanimator.ParseError
+	if !errors.As(err, &perr) || perr.Format != "gif" || perr.Chunk != "image data" {
+		t.Errorf("expected a gif parse error for the image data, got %v", err)
+	}
+
+	// corrupt the version
+	corrupt := append([]byte("GIF88a"), data[6:]...)
+	err = RenderFirstFrame(bytes.NewReader(corrupt), w)
+	if !errors.Is(err, deanimator.ErrMalformed) {
+		t.Errorf("expected malformed error, got %v", err)
+	}
+}
+
+func TestRenderFirstFrameResult(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	res, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := deanimator.Result{
+		InputFormat:  "gif",
+		OutputFormat: "png",
+		MIMEType:     "image/png",
+		Width:        300,
+		Height:       169,
+	}
+	if *res != expected {
+		t.Errorf("expected result %+v, got %+v", expected, *res)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		limits      deanimator.Limits
+		expectLimit string
+	}{
+		{deanimator.Limits{MaxPixels: 300 * 169, MaxBytes: int64(len(data)), MaxFrames: 1}, ""},
+		{deanimator.Limits{MaxPixels: 300*169 - 1}, "MaxPixels"},
+		{deanimator.Limits{MaxBytes: 1000}, "MaxBytes"},
+	} {
+		t.Run(fmt.Sprintf("%+v", tc.limits), func(t *testing.T) {
+			w := bytes.NewBuffer([]byte{})
+			_, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, &deanimator.Options{Limits: &tc.limits})
+			checkLimitError(t, err, tc.expectLimit)
+		})
+	}
+
+	limits := &deanimator.Limits{MaxFrames: 2}
+	err = Validate(context.Background(), bytes.NewReader(data), &deanimator.Options{Limits: limits})
+	checkLimitError(t, err, "MaxFrames")
+	var perr *deanimator.ParseError
+	if !errors.As(err, &perr) || perr.Chunk != "image descriptor" {
+		t.Errorf("expected a parse error for the third image descriptor, got %v", err)
+	}
+}
+
+func checkLimitError(t *testing.T, err error, expectLimit string) {
+	t.Helper()
+	if expectLimit == "" {
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		return
+	}
+	var lerr *deanimator.LimitError
+	if !errors.Is(err, deanimator.ErrLimitExceeded) || !errors.As(err, &lerr) || lerr.Limit != expectLimit {
+		t.Errorf("expected %s to be exceeded, got %v", expectLimit, err)
+	}
+}
+
+// FuzzFormat checks that no input makes any capability of the gif format panic, and that its
+// incremental detector agrees with VerifyAnimated.
+func FuzzFormat(f *testing.F) {
+	var seeds [][]byte
+	for _, file := range []string{"bees.gif", "bubbletea.gif", "thumbsall.gif"} {
+		data, err := ioutil.ReadFile("../testdata/" + file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, data)
+	}
+	fuzztest.Fuzz(f, Format{}, seeds...)
+}
+
+func TestFirstFrame(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := FirstFrame(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*image.Paletted); !ok {
+		t.Errorf("expected an *image.Paletted, got %T", m)
+	}
+	if b := m.Bounds(); b != image.Rect(0, 0, 300, 169) {
+		t.Errorf("expected bounds (0,0)-(300,169), got %v", b)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Inspect(context.Background(), bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 300 || info.Height != 169 || info.Frames != 87 || info.LoopCount != 0 || !info.HasAlpha {
+		t.Errorf("expected 87 frames of 300x169 looping forever with alpha, got %+v", *info)
+	}
+	if len(info.Delays) != 87 || info.Delays[0] != 90*time.Millisecond || info.Duration != 7830*time.Millisecond {
+		t.Errorf("expected 87 delays of 90ms lasting 7.83s, got %v lasting %v", info.Delays, info.Duration)
+	}
+
+	if _, err := Inspect(context.Background(), bytes.NewReader(data[:len(data)-1]), nil); !errors.Is(err, deanimator.ErrTruncated) {
+		t.Errorf("expected a gif missing its trailer to be truncated, got %v", err)
+	}
+}
+
+func TestVerifyAnimated(t *testing.T) {
+	bees, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a still image with a graphic control extension for its transparent color
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Transparent, color.White})
+	still := bytes.NewBuffer([]byte{})
+	if err := gogif.Encode(still, frame, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// an animation without graphic control extensions
+	noGCE := bytes.NewBuffer([]byte{})
+	frame.Palette = color.Palette{color.Black, color.White}
+	if err := gogif.EncodeAll(noGCE, &gogif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{0, 0}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		expect deanimator.State
+	}{
+		{"animated", bees, deanimator.Animated},
+		{"still", still.Bytes(), deanimator.Static},
+		{"no graphic control", noGCE.Bytes(), deanimator.Animated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := VerifyAnimated(context.Background(), bytes.NewReader(tc.data), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.State != tc.expect || d.Reason == "" {
+				t.Errorf("expected %v with a reason, got %v because %q", tc.expect, d.State, d.Reason)
+			}
+
+			// the incremental detector must agree when the data arrives a byte at a time
+			inc := NewIncrementalDetector(nil)
+			var pushed *deanimator.Detection
+			for i := 0; i < len(tc.data) && pushed == nil; i++ {
+				if pushed, err = inc.Write(tc.data[i : i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if pushed == nil || pushed.State != d.State || pushed.Reason != d.Reason {
+				t.Errorf("expected the incremental detector to agree with %+v, got %+v", *d, pushed)
+			}
+		})
+	}
+}
+
+func TestIsAnimatedBlocks(t *testing.T) {
+	// a still image whose image data contains the bytes of two graphic control extensions
+	gceInData := []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00" +
+		"\x00\x00\x00\xff\xff\xff" +
+		"\x2c\x00\x00\x00\x00\x01\x00\x01\x00\x00" +
+		"\x02\x08\x00\x21\xf9\x00\x21\xf9\x00\x00\x00" +
+		"\x3b")
+
+	// an animation without graphic control extensions, which GIF87a does not have
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	gif87a := bytes.NewBuffer([]byte{})
+	if err := gogif.EncodeAll(gif87a, &gogif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{0, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	copy(gif87a.Bytes(), "GIF87a")
+
+	for _, tc := range []struct {
+		name           string
+		data           []byte
+		expectAnimated bool
+	}{
+		{"graphic control bytes in image data", gceInData, false},
+		{"GIF87a", gif87a.Bytes(), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			animated, err := IsAnimated(bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if animated != tc.expectAnimated {
+				t.Errorf("expected IsAnimated == %v, got %v", tc.expectAnimated, animated)
+			}
+		})
+	}
+
+	// detection stops at the second image descriptor, a still image is read to its trailer
+	if _, err := IsAnimated(bytes.NewReader(gceInData[:len(gceInData)-1])); !errors.Is(err, deanimator.ErrTruncated) {
+		t.Errorf("expected a still image missing its trailer to be truncated, got %v", err)
+	}
+}
+
+func TestWalkFrames(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := 0
+	err = WalkFrames(bytes.NewReader(data), func(m image.Image) error {
+		frames++
+		return nil
+	})
+	if err != nil || frames != 87 {
+		t.Errorf("expected 87 frames, got %d, %v", frames, err)
+	}
+
+	// an error returned by fn stops the walk
+	stop := errors.New("stop")
+	frames = 0
+	err = WalkFrames(bytes.NewReader(data), func(m image.Image) error {
+		frames++
+		return stop
+	})
+	if err != stop || frames != 1 {
+		t.Errorf("expected the walk to stop after 1 frame, got %d, %v", frames, err)
+	}
+}
+
+// withComment returns data with a comment extension of size bytes inserted after its global color
+// table.
+func withComment(data []byte, size int) []byte {
+	afterHeader := 6 + 7
+	if fields := data[10]; fields&0x80 != 0 {
+		afterHeader += 3 << (fields&7 + 1)
+	}
+	b := append([]byte{}, data[:afterHeader]...)
+	b = append(b, 0x21, 0xfe)
+	for ; size > 0; size -= 255 {
+		n := 255
+		if size < n {
+			n = size
+		}
+		b = append(b, byte(n))
+		b = append(b, make([]byte, n)...)
+	}
+	b = append(b, 0)
+	return append(b, data[afterHeader:]...)
+}
+
+// seekingReader counts the bytes read from a bytes.Reader.
+type seekingReader struct {
+	*bytes.Reader
+	read int64
+}
+
+func (r *seekingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func (r *seekingReader) ReadByte() (byte, error) {
+	r.read++
+	return r.Reader.ReadByte()
+}
+
+func TestSeek(t *testing.T) {
+	bees, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := withComment(bees, 1<<20)
+
+	r := &seekingReader{Reader: bytes.NewReader(data)}
+	animated, err := IsAnimated(r)
+	if err != nil || !animated {
+		t.Fatalf("expected IsAnimated == true, got %v, %v", animated, err)
+	}
+	if r.read > int64(len(bees)) {
+		t.Errorf("expected the comment to be seeked over, read %d bytes", r.read)
+	}
+
+	// truncated within the comment
+	for _, r := range []io.Reader{bytes.NewReader(data[:1000]), struct{ io.Reader }{bytes.NewReader(data[:1000])}} {
+		if _, err := IsAnimated(r); !errors.Is(err, deanimator.ErrNeedMoreData) {
+			t.Errorf("%T: expected ErrNeedMoreData, got %v", r, err)
+		}
+	}
+}
+
+func BenchmarkIsAnimated(b *testing.B) {
+	bees, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := withComment(bees, 16<<20)
+	for _, bc := range []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{"Seeker", func() io.Reader { return bytes.NewReader(data) }},
+		{"Reader", func() io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := IsAnimated(bc.reader()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRenderFirstFrame(b *testing.B) {
+	bees, err := ioutil.ReadFile("../testdata/bees.gif")
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := withComment(bees, 16<<20)
+	for _, bc := range []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{"Seeker", func() io.Reader { return bytes.NewReader(data) }},
+		{"Reader", func() io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := RenderFirstFrame(bc.reader(), io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
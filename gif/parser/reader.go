@@ -6,17 +6,19 @@ package parser
 import (
 	"bufio"
 	"compress/lzw"
-	"errors"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"io"
+
+	"github.com/slackhq/deanimator"
 )
 
 var (
-	errNotEnough = errors.New("gif: not enough image data")
-	errTooMuch   = errors.New("gif: too much image data")
-	errBadPixel  = errors.New("gif: invalid pixel value")
+	errNotEnough = fmt.Errorf("not enough image data: %w", deanimator.ErrMalformed)
+	errTooMuch   = fmt.Errorf("too much image data: %w", deanimator.ErrMalformed)
+	errBadPixel  = fmt.Errorf("invalid pixel value: %w", deanimator.ErrMalformed)
 )
 
 // If the io.Reader does not also have ReadByte, then decode will introduce its own buffering.
@@ -75,9 +77,50 @@ func readByte(r io.ByteReader) (byte, error) {
 	return b, err
 }
 
+// countingReader counts the bytes read through it, so errors can report their offset. It also
+// remembers how many bytes the read that hit the end of the input still wanted.
+type countingReader struct {
+	r    reader
+	n    int64
+	need int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err == io.EOF {
+		c.need = int64(len(p) - n)
+	}
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	} else if err == io.EOF {
+		c.need = 1
+	}
+	return b, err
+}
+
+// discard skips the next n bytes with deanimator.Discard, which seeks over them when the input
+// implements io.Seeker.
+func (c *countingReader) discard(n int64) error {
+	d, err := deanimator.Discard(c.r, n)
+	c.n += d
+	if err == io.EOF {
+		c.need = n - d
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 // decoder is the type used to decode a GIF file.
 type decoder struct {
-	r reader
+	r    *countingReader
+	ctx  context.Context
+	opts *deanimator.Options
 
 	// From header.
 	vers            string
@@ -95,10 +138,17 @@ type decoder struct {
 	transparentIndex    byte
 	hasTransparentIndex bool
 
+	// Set to walk the frames without decoding their image data, stopping after maxFrames if it is
+	// not 0.
+	skipImageData bool
+	maxFrames     int
+	transparent   bool
+
 	// Computed.
 	globalColorTable color.Palette
 
 	// Used when decoding.
+	frames   int
 	delay    []int
 	disposal []byte
 	image    []*image.Paletted
@@ -211,13 +261,23 @@ func (b *blockReader) close() error {
 	return errTooMuch
 }
 
-// decode reads a GIF image from r and stores the result in d.
-func (d *decoder) decode(r io.Reader, configOnly, keepAllFrames bool) error {
+// decode reads a GIF image from r and stores the result in d. If walkAllFrames is set, every frame
+// is read up to the trailer even if only the first one is kept. d.ctx is checked between blocks and
+// the limits of d.opts are enforced.
+func (d *decoder) decode(r io.Reader, configOnly, keepAllFrames, walkAllFrames bool) error {
+	if d.ctx == nil {
+		d.ctx = context.Background()
+	}
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	r = d.opts.LimitReader(r)
+
 	// Add buffering if r does not provide ReadByte.
 	if rr, ok := r.(reader); ok {
-		d.r = rr
+		d.r = &countingReader{r: rr}
 	} else {
-		d.r = bufio.NewReader(r)
+		d.r = &countingReader{r: bufio.NewReader(r)}
 	}
 
 	d.loopCount = -1
@@ -231,33 +291,56 @@ func (d *decoder) decode(r io.Reader, configOnly, keepAllFrames bool) error {
 	}
 
 	for {
+		ok, err := d.readFrame(keepAllFrames)
+		if err != nil || !ok {
+			return err
+		}
+		if d.maxFrames > 0 && d.frames == d.maxFrames {
+			return nil
+		}
+		if !keepAllFrames && !walkAllFrames && len(d.image) == 1 {
+			return nil
+		}
+	}
+}
+
+// readFrame reads the blocks up to and including the next image descriptor and its image data. It
+// reports false once it reads the trailer instead.
+func (d *decoder) readFrame(keepAllFrames bool) (bool, error) {
+	for {
+		if err := d.ctx.Err(); err != nil {
+			return false, err
+		}
 		c, err := readByte(d.r)
 		if err != nil {
-			return fmt.Errorf("gif: reading frames: %v", err)
+			return false, d.parseError("block", err)
 		}
 		switch c {
 		case sExtension:
 			if err = d.readExtension(); err != nil {
-				return err
+				return false, err
 			}
 
 		case sImageDescriptor:
-			if err = d.readImageDescriptor(keepAllFrames); err != nil {
-				return err
+			d.frames++
+			if err := d.opts.CheckFrames(d.frames); err != nil {
+				return false, d.parseError("image descriptor", err)
 			}
-
-			if !keepAllFrames && len(d.image) == 1 {
-				return nil
+			if d.skipImageData {
+				err = d.skipImage()
+			} else {
+				err = d.readImageDescriptor(keepAllFrames)
 			}
+			return err == nil, err
 
 		case sTrailer:
-			if len(d.image) == 0 {
-				return fmt.Errorf("gif: missing image data")
+			if d.frames == 0 {
+				return false, d.malformed("trailer", "missing image data")
 			}
-			return nil
+			return false, nil
 
 		default:
-			return fmt.Errorf("gif: unknown block type: 0x%.2x", c)
+			return false, d.malformed("block", fmt.Sprintf("unknown block type 0x%.2x", c))
 		}
 	}
 }
@@ -265,14 +348,18 @@ func (d *decoder) decode(r io.Reader, configOnly, keepAllFrames bool) error {
 func (d *decoder) readHeaderAndScreenDescriptor() error {
 	err := readFull(d.r, d.tmp[:13])
 	if err != nil {
-		return fmt.Errorf("gif: reading header: %v", err)
+		return d.parseError("header", err)
 	}
 	d.vers = string(d.tmp[:6])
 	if d.vers != "GIF87a" && d.vers != "GIF89a" {
-		return fmt.Errorf("gif: can't recognize format %q", d.vers)
+		return d.malformed("header", fmt.Sprintf("can't recognize format %q", d.vers))
 	}
 	d.width = int(d.tmp[6]) + int(d.tmp[7])<<8
 	d.height = int(d.tmp[8]) + int(d.tmp[9])<<8
+	// frames must fit within the screen, so checking it bounds every frame allocation
+	if err := d.opts.CheckPixels(d.width, d.height); err != nil {
+		return d.parseError("header", err)
+	}
 	if fields := d.tmp[10]; fields&fColorTable != 0 {
 		d.backgroundIndex = d.tmp[11]
 		// readColorTable overwrites the contents of d.tmp, but that's OK.
@@ -288,7 +375,7 @@ func (d *decoder) readColorTable(fields byte) (color.Palette, error) {
 	n := 1 << (1 + uint(fields&fColorTableBitsMask))
 	err := readFull(d.r, d.tmp[:3*n])
 	if err != nil {
-		return nil, fmt.Errorf("gif: reading color table: %s", err)
+		return nil, d.parseError("color table", err)
 	}
 	j, p := 0, make(color.Palette, n)
 	for i := range p {
@@ -301,7 +388,7 @@ func (d *decoder) readColorTable(fields byte) (color.Palette, error) {
 func (d *decoder) readExtension() error {
 	extension, err := readByte(d.r)
 	if err != nil {
-		return fmt.Errorf("gif: reading extension: %v", err)
+		return d.parseError("extension", err)
 	}
 	size := 0
 	switch extension {
@@ -314,16 +401,16 @@ func (d *decoder) readExtension() error {
 	case eApplication:
 		b, err := readByte(d.r)
 		if err != nil {
-			return fmt.Errorf("gif: reading extension: %v", err)
+			return d.parseError("application extension", err)
 		}
 		// The spec requires size be 11, but Adobe sometimes uses 10.
 		size = int(b)
 	default:
-		return fmt.Errorf("gif: unknown extension 0x%.2x", extension)
+		return d.malformed("extension", fmt.Sprintf("unknown extension 0x%.2x", extension))
 	}
 	if size > 0 {
 		if err := readFull(d.r, d.tmp[:size]); err != nil {
-			return fmt.Errorf("gif: reading extension: %v", err)
+			return d.parseError("extension", err)
 		}
 	}
 
@@ -332,7 +419,7 @@ func (d *decoder) readExtension() error {
 	if extension == eApplication && string(d.tmp[:size]) == "NETSCAPE2.0" {
 		n, err := d.readBlock()
 		if err != nil {
-			return fmt.Errorf("gif: reading extension: %v", err)
+			return d.parseError("extension", err)
 		}
 		if n == 0 {
 			return nil
@@ -342,9 +429,9 @@ func (d *decoder) readExtension() error {
 		}
 	}
 	for {
-		n, err := d.readBlock()
+		n, err := d.skipBlock()
 		if err != nil {
-			return fmt.Errorf("gif: reading extension: %v", err)
+			return d.parseError("extension", err)
 		}
 		if n == 0 {
 			return nil
@@ -354,10 +441,10 @@ func (d *decoder) readExtension() error {
 
 func (d *decoder) readGraphicControl() error {
 	if err := readFull(d.r, d.tmp[:6]); err != nil {
-		return fmt.Errorf("gif: can't read graphic control: %s", err)
+		return d.parseError("graphic control extension", err)
 	}
 	if d.tmp[0] != 4 {
-		return fmt.Errorf("gif: invalid graphic control extension block size: %d", d.tmp[0])
+		return d.malformed("graphic control extension", fmt.Sprintf("invalid block size: %d", d.tmp[0]))
 	}
 	flags := d.tmp[1]
 	d.disposalMethod = (flags & gcDisposalMethodMask) >> 2
@@ -367,7 +454,7 @@ func (d *decoder) readGraphicControl() error {
 		d.hasTransparentIndex = true
 	}
 	if d.tmp[5] != 0 {
-		return fmt.Errorf("gif: invalid graphic control extension block terminator: %d", d.tmp[5])
+		return d.malformed("graphic control extension", fmt.Sprintf("invalid block terminator: %d", d.tmp[5]))
 	}
 	return nil
 }
@@ -385,7 +472,7 @@ func (d *decoder) readImageDescriptor(keepAllFrames bool) error {
 		}
 	} else {
 		if d.globalColorTable == nil {
-			return errors.New("gif: no color table")
+			return d.malformed("image descriptor", "no color table")
 		}
 		m.Palette = d.globalColorTable
 	}
@@ -411,20 +498,21 @@ func (d *decoder) readImageDescriptor(keepAllFrames bool) error {
 	}
 	litWidth, err := readByte(d.r)
 	if err != nil {
-		return fmt.Errorf("gif: reading image data: %v", err)
+		return d.parseError("image data", err)
 	}
 	if litWidth < 2 || litWidth > 8 {
-		return fmt.Errorf("gif: pixel size in decode out of range: %d", litWidth)
+		return d.malformed("image data", fmt.Sprintf("pixel size in decode out of range: %d", litWidth))
 	}
 	// A wonderfully Go-like piece of magic.
 	br := &blockReader{d: d}
 	lzwr := lzw.NewReader(br, lzw.LSB, int(litWidth))
 	defer lzwr.Close()
 	if err = readFull(lzwr, m.Pix); err != nil {
-		if err != io.ErrUnexpectedEOF {
-			return fmt.Errorf("gif: reading image data: %v", err)
+		if err != io.ErrUnexpectedEOF || br.err == io.ErrUnexpectedEOF {
+			// either a read error, or the input ended within the image data
+			return d.parseError("image data", err)
 		}
-		return errNotEnough
+		return d.parseError("image data", errNotEnough)
 	}
 	// In theory, both lzwr and br should be exhausted. Reading from them
 	// should yield (0, io.EOF).
@@ -439,24 +527,22 @@ func (d *decoder) readImageDescriptor(keepAllFrames bool) error {
 	// See https://golang.org/issue/9856 for an example GIF.
 	if n, err := lzwr.Read(d.tmp[256:257]); n != 0 || (err != io.EOF && err != io.ErrUnexpectedEOF) {
 		if err != nil {
-			return fmt.Errorf("gif: reading image data: %v", err)
+			return d.parseError("image data", err)
 		}
-		return errTooMuch
+		return d.parseError("image data", errTooMuch)
 	}
 
 	// In practice, some GIFs have an extra byte in the data sub-block
 	// stream, which we ignore. See https://golang.org/issue/16146.
-	if err := br.close(); err == errTooMuch {
-		return errTooMuch
-	} else if err != nil {
-		return fmt.Errorf("gif: reading image data: %v", err)
+	if err := br.close(); err != nil {
+		return d.parseError("image data", err)
 	}
 
 	// Check that the color indexes are inside the palette.
 	if len(m.Palette) < 256 {
 		for _, pixel := range m.Pix {
 			if int(pixel) >= len(m.Palette) {
-				return errBadPixel
+				return d.parseError("image data", errBadPixel)
 			}
 		}
 	}
@@ -475,19 +561,58 @@ func (d *decoder) readImageDescriptor(keepAllFrames bool) error {
 	// "The scope of this extension is the first graphic rendering block
 	// to follow." We therefore reset the GCE fields to zero.
 	d.delayTime = 0
+	d.disposalMethod = 0
+	d.hasTransparentIndex = false
+	return nil
+}
+
+// skipImage reads an image descriptor and skips over its image data, only recording the frame's
+// graphic control fields.
+func (d *decoder) skipImage() error {
+	if _, err := d.readImageBounds(); err != nil {
+		return err
+	}
+	if d.imageFields&fColorTable != 0 {
+		if _, err := d.readColorTable(d.imageFields); err != nil {
+			return err
+		}
+	} else if d.globalColorTable == nil {
+		return d.malformed("image descriptor", "no color table")
+	}
+	litWidth, err := readByte(d.r)
+	if err != nil {
+		return d.parseError("image data", err)
+	}
+	if litWidth < 2 || litWidth > 8 {
+		return d.malformed("image data", fmt.Sprintf("pixel size in decode out of range: %d", litWidth))
+	}
+	for {
+		if err := d.ctx.Err(); err != nil {
+			return err
+		}
+		n, err := d.skipBlock()
+		if err != nil {
+			return d.parseError("image data", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	d.delay = append(d.delay, d.delayTime)
+	d.disposal = append(d.disposal, d.disposalMethod)
+	d.transparent = d.transparent || d.hasTransparentIndex
+	d.delayTime = 0
+	d.disposalMethod = 0
 	d.hasTransparentIndex = false
 	return nil
 }
 
 func (d *decoder) newImageFromDescriptor() (*image.Paletted, error) {
-	if err := readFull(d.r, d.tmp[:9]); err != nil {
-		return nil, fmt.Errorf("gif: can't read image descriptor: %s", err)
+	bounds, err := d.readImageBounds()
+	if err != nil {
+		return nil, err
 	}
-	left := int(d.tmp[0]) + int(d.tmp[1])<<8
-	top := int(d.tmp[2]) + int(d.tmp[3])<<8
-	width := int(d.tmp[4]) + int(d.tmp[5])<<8
-	height := int(d.tmp[6]) + int(d.tmp[7])<<8
-	d.imageFields = d.tmp[8]
 
 	// The GIF89a spec, Section 20 (Image Descriptor) says: "Each image must
 	// fit within the boundaries of the Logical Screen, as defined in the
@@ -505,13 +630,41 @@ func (d *decoder) newImageFromDescriptor() (*image.Paletted, error) {
 	// explicitly compare frameBounds.Max (left+width, top+height) against
 	// imageBounds.Max (d.width, d.height) and not frameBounds.Min (left, top)
 	// against imageBounds.Min (0, 0).
-	if left+width > d.width || top+height > d.height {
-		return nil, errors.New("gif: frame bounds larger than image bounds")
+	if bounds.Max.X > d.width || bounds.Max.Y > d.height {
+		return nil, d.malformed("image descriptor", "frame bounds larger than image bounds")
 	}
-	return image.NewPaletted(image.Rectangle{
+	return image.NewPaletted(bounds, nil), nil
+}
+
+// readImageBounds reads an image descriptor and returns the bounds of the frame. They are not
+// checked against the logical screen, frames that are not decoded may lie outside of it.
+func (d *decoder) readImageBounds() (image.Rectangle, error) {
+	if err := readFull(d.r, d.tmp[:9]); err != nil {
+		return image.Rectangle{}, d.parseError("image descriptor", err)
+	}
+	left := int(d.tmp[0]) + int(d.tmp[1])<<8
+	top := int(d.tmp[2]) + int(d.tmp[3])<<8
+	width := int(d.tmp[4]) + int(d.tmp[5])<<8
+	height := int(d.tmp[6]) + int(d.tmp[7])<<8
+	d.imageFields = d.tmp[8]
+	return image.Rectangle{
 		Min: image.Point{left, top},
 		Max: image.Point{left + width, top + height},
-	}, nil), nil
+	}, nil
+}
+
+// parseError wraps err in a *deanimator.ParseError for block at the current offset. An unexpected
+// EOF is reported as a *deanimator.NeedMoreDataError for the rest of the block or sub-block.
+func (d *decoder) parseError(block string, err error) error {
+	if err == io.ErrUnexpectedEOF {
+		err = deanimator.NeedMoreData(d.r.need)
+	}
+	return &deanimator.ParseError{Format: "gif", Offset: d.r.n, Chunk: block, Err: err}
+}
+
+// malformed returns a *deanimator.ParseError wrapping deanimator.ErrMalformed for block.
+func (d *decoder) malformed(block, msg string) error {
+	return d.parseError(block, fmt.Errorf("%s: %w", msg, deanimator.ErrMalformed))
 }
 
 func (d *decoder) readBlock() (int, error) {
@@ -525,6 +678,19 @@ func (d *decoder) readBlock() (int, error) {
 	return int(n), nil
 }
 
+// skipBlock is like readBlock, but seeks over the data of the sub-block when the input implements
+// io.Seeker.
+func (d *decoder) skipBlock() (int, error) {
+	if _, ok := d.r.r.(io.Seeker); !ok {
+		return d.readBlock()
+	}
+	n, err := readByte(d.r)
+	if n == 0 || err != nil {
+		return 0, err
+	}
+	return int(n), d.r.discard(int64(n))
+}
+
 // interlaceScan defines the ordering for a pass of the interlace algorithm.
 type interlaceScan struct {
 	skip, start int
@@ -557,11 +723,162 @@ func uninterlace(m *image.Paletted) {
 }
 
 // Decode reads a GIF image from r and returns the first embedded
-// image as an image.Image.
+// image as an image.Image. If r is a *Source, its context and options are applied and its Screen
+// is set.
 func Decode(r io.Reader) (image.Image, error) {
-	var d decoder
-	if err := d.decode(r, false, false); err != nil {
+	if s, ok := r.(*Source); ok {
+		m, screen, err := DecodeScreen(s.Ctx, s.Reader, s.Opts)
+		if err != nil {
+			return nil, err
+		}
+		s.Screen = screen
+		return m, nil
+	}
+	return DecodeContext(context.Background(), r, nil)
+}
+
+// A Source is a reader carrying the context and options of a call to Decode, which has no room for
+// them in its signature. The gif package passes one to its DecodeFunc, so a DecodeFunc wrapping
+// Decode keeps the limits and the logical screen of the built-in parser.
+type Source struct {
+	io.Reader
+	Ctx  context.Context
+	Opts *deanimator.Options
+
+	// Screen is set by Decode to the logical screen the first frame is drawn onto.
+	Screen *Screen
+}
+
+// DecodeContext is like Decode, but checks ctx between blocks and enforces the limits of opts.
+func DecodeContext(ctx context.Context, r io.Reader, opts *deanimator.Options) (image.Image, error) {
+	d := decoder{ctx: ctx, opts: opts}
+	if err := d.decode(r, false, false, false); err != nil {
 		return nil, err
 	}
 	return d.image[0], nil
 }
+
+// A Screen is the logical screen of a GIF, onto which its frames are drawn.
+type Screen struct {
+	// Width and Height are the size of the logical screen.
+	Width, Height int
+
+	// BackgroundIndex is the index of the background color in GlobalColorTable.
+	BackgroundIndex byte
+
+	// GlobalColorTable is nil if the GIF has none.
+	GlobalColorTable color.Palette
+}
+
+// DecodeScreen is like DecodeContext, but also returns the logical screen the first frame is drawn
+// onto.
+func DecodeScreen(ctx context.Context, r io.Reader, opts *deanimator.Options) (*image.Paletted, *Screen, error) {
+	d := decoder{ctx: ctx, opts: opts}
+	if err := d.decode(r, false, false, false); err != nil {
+		return nil, nil, err
+	}
+	return d.image[0], &Screen{
+		Width:            d.width,
+		Height:           d.height,
+		BackgroundIndex:  d.backgroundIndex,
+		GlobalColorTable: d.globalColorTable,
+	}, nil
+}
+
+// A Frame is a frame of a GIF, as returned by FrameDecoder.Next.
+type Frame struct {
+	// Image is the frame, its bounds place it on the logical screen.
+	Image *image.Paletted
+
+	// Delay is the delay of the frame in 100ths of a second, and Disposal its disposal method,
+	// DisposalNone if it has none.
+	Delay    int
+	Disposal byte
+}
+
+// A FrameDecoder decodes the frames of a GIF one at a time, only holding one of them in memory.
+type FrameDecoder struct {
+	d decoder
+}
+
+// NewFrameDecoder reads the header of the GIF in r and returns a FrameDecoder for its frames, and
+// its logical screen. ctx is checked between blocks and the limits of opts are enforced.
+func NewFrameDecoder(ctx context.Context, r io.Reader, opts *deanimator.Options) (*FrameDecoder, *Screen, error) {
+	f := &FrameDecoder{d: decoder{ctx: ctx, opts: opts}}
+	if err := f.d.decode(r, true, false, false); err != nil {
+		return nil, nil, err
+	}
+	return f, &Screen{
+		Width:            f.d.width,
+		Height:           f.d.height,
+		BackgroundIndex:  f.d.backgroundIndex,
+		GlobalColorTable: f.d.globalColorTable,
+	}, nil
+}
+
+// Next decodes the next frame. It returns io.EOF after the last frame.
+func (f *FrameDecoder) Next() (*Frame, error) {
+	d := &f.d
+	ok, err := d.readFrame(true)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, io.EOF
+	}
+	frame := &Frame{Image: d.image[0], Delay: d.delay[0], Disposal: d.disposal[0]}
+	if frame.Disposal == 0 {
+		frame.Disposal = DisposalNone
+	}
+	d.image, d.delay, d.disposal = d.image[:0], d.delay[:0], d.disposal[:0]
+	return frame, nil
+}
+
+// Validate reads a whole GIF image from r, decoding every frame up to the trailer, and returns
+// the first error encountered. Only one frame is held in memory at a time. ctx is checked between
+// blocks and the limits of opts are enforced.
+func Validate(ctx context.Context, r io.Reader, opts *deanimator.Options) error {
+	d := decoder{ctx: ctx, opts: opts}
+	return d.decode(r, false, false, true)
+}
+
+// Metadata describes a GIF and its frames, as read by Inspect.
+type Metadata struct {
+	// Width and Height are the size of the logical screen.
+	Width, Height int
+
+	// LoopCount is as in image/gif.GIF: -1 plays the animation once, 0 loops it forever and n
+	// loops it n more times.
+	LoopCount int
+
+	// Delay and Disposal are the delay, in 100ths of a second, and the disposal method of each
+	// frame.
+	Delay    []int
+	Disposal []byte
+
+	// Transparent reports whether any frame has a transparent color.
+	Transparent bool
+}
+
+// Inspect reads a whole GIF image from r without decoding its image data, and returns the
+// metadata of the image and its frames. ctx is checked between blocks and the limits of opts are
+// enforced.
+func Inspect(ctx context.Context, r io.Reader, opts *deanimator.Options) (*Metadata, error) {
+	return InspectFrames(ctx, r, opts, 0)
+}
+
+// InspectFrames is like Inspect, but stops reading after the first n frames. An n of 0 reads all
+// frames.
+func InspectFrames(ctx context.Context, r io.Reader, opts *deanimator.Options, n int) (*Metadata, error) {
+	d := decoder{ctx: ctx, opts: opts, skipImageData: true, maxFrames: n}
+	if err := d.decode(r, false, false, true); err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		Width:       d.width,
+		Height:      d.height,
+		LoopCount:   d.loopCount,
+		Delay:       d.delay,
+		Disposal:    d.disposal,
+		Transparent: d.transparent,
+	}, nil
+}
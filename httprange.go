@@ -0,0 +1,206 @@
+package deanimator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMinRange is the least number of bytes an HTTPRangeReaderAt fetches per request, unless
+// configured otherwise.
+const DefaultMinRange = 32 << 10
+
+// An HTTPRangeReaderAt reads a remote resource with HTTP Range requests, so functions like
+// DetectAt only download the parts of an image they look at. Small reads are coalesced: every
+// request fetches at least MinRange bytes, and reads falling within the last fetched range are
+// served from it. It is safe for concurrent use.
+//
+// As io.ReaderAt has no way to pass a context, every request of the reader is made with the
+// context it was created with. Create a reader per operation that should be cancelable on its own.
+type HTTPRangeReaderAt struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	opts   *Options
+
+	// MinRange is the least number of bytes fetched per request.
+	MinRange int64
+
+	mu       sync.Mutex
+	requests int
+	size     int64
+	cache    []byte
+	start    int64 // offset of cache
+}
+
+// NewHTTPRangeReaderAt returns an HTTPRangeReaderAt for url, whose requests are made with client, or
+// http.DefaultClient if it is nil. Every request, not only the first, fails once ctx is done. It
+// fetches the first DefaultMinRange bytes to learn the size of the resource, making a HEAD request
+// for it if the server does not say. Servers that ignore Range requests are read in full by this
+// first request, failing with a *LimitError once that is more than the MaxBytes limit of opts.
+func NewHTTPRangeReaderAt(ctx context.Context, client *http.Client, url string, opts *Options) (*HTTPRangeReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	h := &HTTPRangeReaderAt{ctx: ctx, client: client, url: url, opts: opts, MinRange: DefaultMinRange}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.fetch(0, h.MinRange); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Size returns the size of the resource in bytes.
+func (h *HTTPRangeReaderAt) Size() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.size
+}
+
+// Requests returns the number of requests made so far.
+func (h *HTTPRangeReaderAt) Requests() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests
+}
+
+// ReadAt implements io.ReaderAt. It returns io.EOF for reads past the end of the resource, and
+// io.ErrUnexpectedEOF if the server sent less of it than requested.
+func (h *HTTPRangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("deanimator: negative offset")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if off >= h.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > h.size {
+		end = h.size
+	}
+	if off < h.start || end > h.start+int64(len(h.cache)) {
+		n := end - off
+		if n < h.MinRange {
+			n = h.MinRange
+		}
+		if err := h.fetch(off, n); err != nil {
+			return 0, err
+		}
+		if off < h.start || off > h.start+int64(len(h.cache)) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if cached := h.start + int64(len(h.cache)); end > cached {
+			end = cached
+		}
+	}
+	n := copy(p, h.cache[off-h.start:end-h.start])
+	if n < len(p) && off+int64(n) < h.size {
+		// the server sent less than the range asked for
+		return n, io.ErrUnexpectedEOF
+	} else if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetch replaces the cache with n bytes of the resource starting at off, fewer at its end.
+func (h *HTTPRangeReaderAt) fetch(off, n int64) error {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	h.requests++
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if h.cache, err = io.ReadAll(io.LimitReader(resp.Body, n)); err != nil {
+			return err
+		}
+		if size < 0 {
+			// the server did not say how large the resource is
+			switch {
+			case int64(len(h.cache)) < n:
+				// the resource ends within the range
+				size = start + int64(len(h.cache))
+			case h.requests > 1:
+				size = h.size
+			default:
+				if size, err = h.head(); err != nil {
+					return err
+				}
+			}
+		}
+		h.start, h.size = start, size
+	case http.StatusOK:
+		// the server ignored the range and sent the whole resource
+		if h.cache, err = io.ReadAll(h.opts.LimitReader(resp.Body)); err != nil {
+			return err
+		}
+		h.start, h.size = 0, int64(len(h.cache))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the resource is empty, or shrank
+		h.cache, h.start, h.size = nil, 0, 0
+		if _, size, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && size >= 0 {
+			h.size = size
+		}
+	default:
+		return fmt.Errorf("deanimator: fetching %s: %s", h.url, resp.Status)
+	}
+	return nil
+}
+
+// head returns the size of the resource from the Content-Length of a HEAD request.
+func (h *HTTPRangeReaderAt) head() (int64, error) {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodHead, h.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	h.requests++
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return 0, fmt.Errorf("deanimator: the size of %s is unknown: %s", h.url, resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// parseContentRange parses the start and complete length of a Content-Range header such as
+// "bytes 0-99/1000" or "bytes */1000". The length is -1 if it is unknown, as in "bytes 0-99/*".
+func parseContentRange(s string) (start, size int64, err error) {
+	rangeSpec, sizeSpec, ok := strings.Cut(strings.TrimPrefix(s, "bytes "), "/")
+	if !ok || !strings.HasPrefix(s, "bytes ") || (rangeSpec == "*" && sizeSpec == "*") {
+		return 0, 0, fmt.Errorf("deanimator: invalid Content-Range %q", s)
+	}
+	if sizeSpec == "*" {
+		size = -1
+	} else if size, err = strconv.ParseInt(sizeSpec, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("deanimator: invalid Content-Range %q", s)
+	}
+	if rangeSpec != "*" {
+		first, _, _ := strings.Cut(rangeSpec, "-")
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("deanimator: invalid Content-Range %q", s)
+		}
+	}
+	return start, size, nil
+}
@@ -0,0 +1,77 @@
+// Package animation composites the frames of an animation onto its canvas, the way browsers display
+// them.
+package animation
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Disposal is what happens to the area of a frame once the next frame is drawn.
+type Disposal int
+
+const (
+	// DisposeNone leaves the frame on the canvas.
+	DisposeNone Disposal = iota
+	// DisposeBackground clears the area of the frame to the background.
+	DisposeBackground
+	// DisposePrevious restores the area of the frame to what it was before the frame was drawn.
+	DisposePrevious
+)
+
+// A Canvas composites the frames of an animation, one at a time.
+type Canvas struct {
+	image      *image.RGBA
+	background *image.Uniform
+
+	// the disposal of the last frame drawn, applied before the next one
+	dispose  Disposal
+	area     image.Rectangle
+	previous *image.RGBA
+}
+
+// NewCanvas returns a canvas of width by height filled with background, which is also what
+// DisposeBackground clears to.
+func NewCanvas(width, height int, background color.Color) *Canvas {
+	c := &Canvas{
+		image:      image.NewRGBA(image.Rect(0, 0, width, height)),
+		background: image.NewUniform(background),
+	}
+	draw.Draw(c.image, c.image.Rect, c.background, image.Point{}, draw.Src)
+	return c
+}
+
+// Draw disposes of the last frame drawn, then draws m with its top left corner at at. It blends m
+// over the canvas, or replaces the canvas under it if blend is false. dispose is applied when the
+// next frame is drawn.
+func (c *Canvas) Draw(m image.Image, at image.Point, blend bool, dispose Disposal) {
+	switch c.dispose {
+	case DisposeBackground:
+		draw.Draw(c.image, c.area, c.background, image.Point{}, draw.Src)
+	case DisposePrevious:
+		draw.Draw(c.image, c.area, c.previous, c.area.Min, draw.Src)
+	}
+
+	bounds := m.Bounds()
+	c.dispose = dispose
+	c.area = image.Rectangle{Min: at, Max: at.Add(bounds.Size())}.Intersect(c.image.Rect)
+	if dispose == DisposePrevious {
+		if c.previous == nil {
+			c.previous = image.NewRGBA(c.image.Rect)
+		}
+		draw.Draw(c.previous, c.area, c.image, c.area.Min, draw.Src)
+	}
+
+	op := draw.Over
+	if !blend {
+		op = draw.Src
+	}
+	draw.Draw(c.image, c.area, m, bounds.Min.Add(c.area.Min.Sub(at)), op)
+}
+
+// Image returns the canvas as it is displayed after the last frame drawn. It is overwritten by
+// the next call to Draw.
+func (c *Canvas) Image() *image.RGBA {
+	return c.image
+}
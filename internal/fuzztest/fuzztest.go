@@ -0,0 +1,86 @@
+// Package fuzztest fuzzes a deanimator.Format through every capability it implements.
+package fuzztest
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/slackhq/deanimator"
+)
+
+// Fuzz fuzzes format starting from the seed images, checking with Check that no input makes it
+// panic.
+func Fuzz(f *testing.F, format deanimator.Format, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Check(t, format, data)
+	})
+}
+
+// Check runs data through the methods of format and of every optional interface it implements,
+// with limits that keep large images cheap. Errors are expected, only panics and the incremental
+// detector disagreeing with VerifyAnimated fail t.
+func Check(t *testing.T, format deanimator.Format, data []byte) {
+	t.Helper()
+	ctx := context.Background()
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxPixels: 1 << 20, MaxBytes: 1 << 20}}
+
+	format.IsAnimated(ctx, bytes.NewReader(data), opts)
+	format.RenderFirstFrame(ctx, bytes.NewReader(data), io.Discard, opts)
+	if s, ok := format.(deanimator.Sniffer); ok {
+		s.Sniff(func(n int) ([]byte, error) {
+			if n > len(data) {
+				return data, io.EOF
+			}
+			return data[:n], nil
+		})
+	}
+	if d, ok := format.(deanimator.FirstFrameDecoder); ok {
+		d.FirstFrame(ctx, bytes.NewReader(data), opts)
+	}
+	if i, ok := format.(deanimator.Inspector); ok {
+		i.Inspect(ctx, bytes.NewReader(data), opts)
+	}
+	if v, ok := format.(deanimator.Validator); ok {
+		v.Validate(ctx, bytes.NewReader(data), opts)
+	}
+	if i, ok := format.(deanimator.InputFormatter); ok {
+		i.InputFormat(ctx, bytes.NewReader(data), opts)
+	}
+	if r, ok := format.(deanimator.FrameRenderer); ok {
+		r.RenderFrame(ctx, bytes.NewReader(data), io.Discard, 0, opts)
+		r.RenderFrame(ctx, bytes.NewReader(data), io.Discard, 1, opts)
+	}
+	if w, ok := format.(deanimator.FrameWalker); ok {
+		w.WalkFrames(ctx, bytes.NewReader(data), opts, func(image.Image) error { return nil })
+	}
+
+	v, ok := format.(deanimator.AnimationVerifier)
+	if !ok {
+		return
+	}
+	d, err := v.VerifyAnimated(ctx, bytes.NewReader(data), opts)
+	iv, ok := format.(deanimator.IncrementalVerifier)
+	if !ok {
+		return
+	}
+	// the incremental detector, fed in small pieces, has to agree with VerifyAnimated
+	inc := iv.NewIncrementalDetector(opts)
+	var pushed *deanimator.Detection
+	var incErr error
+	for i := 0; i < len(data) && pushed == nil && incErr == nil; i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		pushed, incErr = inc.Write(data[i:end])
+	}
+	if err == nil && pushed != nil && pushed.State != d.State {
+		t.Errorf("VerifyAnimated returned %+v, the incremental detector %+v", *d, *pushed)
+	}
+}
@@ -0,0 +1,107 @@
+// Package incremental helps formats parse image data that is pushed to them in pieces, instead of
+// pulled from an io.Reader.
+package incremental
+
+// A Parser hands the data written to it to steps, each of which asks for a fixed number of bytes.
+// A step schedules the next one with Read, and may Skip bytes before it. Parsing ends once a step
+// schedules nothing, and data written after that is ignored.
+type Parser struct {
+	buf    []byte
+	want   int
+	fn     func([]byte) error
+	skip   int64
+	offset int64
+	start  int64
+}
+
+// New returns a parser whose first step reads n bytes and passes them to fn.
+func New(n int, fn func([]byte) error) *Parser {
+	p := &Parser{}
+	p.Read(n, fn)
+	return p
+}
+
+// Read schedules the next step, which passes the next n bytes to fn. The bytes are only valid
+// until fn returns.
+func (p *Parser) Read(n int, fn func([]byte) error) {
+	p.want, p.fn = n, fn
+}
+
+// Skip discards the next n bytes before the next step.
+func (p *Parser) Skip(n int64) {
+	p.skip += n
+}
+
+// Offset returns the offset of the bytes passed to the current step.
+func (p *Parser) Offset() int64 {
+	return p.start
+}
+
+// TakeSkip consumes the bytes the parser skips before the next step as if they were written, and
+// returns how many. Callers reading at offsets can jump over them.
+func (p *Parser) TakeSkip() int64 {
+	n := p.skip
+	p.skip = 0
+	p.offset += n
+	return n
+}
+
+// Need returns how many more bytes the next step needs after any skipped ones, 0 once parsing ended.
+func (p *Parser) Need() int {
+	if p.fn == nil {
+		return 0
+	}
+	return p.want - len(p.buf)
+}
+
+// Done reports whether parsing ended.
+func (p *Parser) Done() bool {
+	return p.fn == nil
+}
+
+// Write runs the steps the bytes of b complete, buffering at most the bytes of one step. It returns
+// the first error of a step, after which the parser is done.
+func (p *Parser) Write(b []byte) error {
+	for p.fn != nil {
+		if p.skip > 0 {
+			if len(b) == 0 {
+				return nil
+			}
+			n := len(b)
+			if int64(n) > p.skip {
+				n = int(p.skip)
+			}
+			b = b[n:]
+			p.skip -= int64(n)
+			p.offset += int64(n)
+			continue
+		}
+
+		var data []byte
+		if len(p.buf) == 0 && len(b) >= p.want {
+			// the step is complete within b, so it needs no copy
+			data, b = b[:p.want], b[p.want:]
+		} else {
+			n := p.want - len(p.buf)
+			if n > len(b) {
+				n = len(b)
+			}
+			p.buf = append(p.buf, b[:n]...)
+			b = b[n:]
+			if len(p.buf) < p.want {
+				return nil
+			}
+			data, p.buf = p.buf, p.buf[:0]
+		}
+		p.start = p.offset
+		p.offset += int64(len(data))
+
+		fn := p.fn
+		p.fn = nil
+		if err := fn(data); err != nil {
+			p.fn = nil
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package deanimator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrLimitExceeded indicates an image needed more resources than the Limits of the call allow. The
+// returned error is a *LimitError describing which limit.
+var ErrLimitExceeded = errors.New("deanimator: limit exceeded")
+
+// Limits bounds the resources a single call may spend on an image, to defend against images
+// crafted to exhaust memory or time. Zero fields are unlimited.
+type Limits struct {
+	// MaxPixels is the largest canvas or frame, in pixels, a format may allocate.
+	MaxPixels int64
+
+	// MaxBytes is the most input a format may read.
+	MaxBytes int64
+
+	// MaxFrames is the most frames a format may scan.
+	MaxFrames int
+
+	// MaxChunkSize is the largest chunk or block a format may read or buffer.
+	MaxChunkSize int64
+}
+
+// A LimitError reports a value that exceeded one of the Limits.
+type LimitError struct {
+	// Limit is the name of the Limits field that was exceeded, for example "MaxPixels".
+	Limit string
+
+	// Value is the value that exceeded the limit, and Max the limit itself.
+	Value, Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("deanimator: %d exceeds %s of %d", e.Value, e.Limit, e.Max)
+}
+
+// Is reports whether target is ErrLimitExceeded.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func check(limit string, value, max int64) error {
+	if max > 0 && value > max {
+		return &LimitError{Limit: limit, Value: value, Max: max}
+	}
+	return nil
+}
+
+func (o *Options) limits() Limits {
+	if o == nil || o.Limits == nil {
+		return Limits{}
+	}
+	return *o.Limits
+}
+
+// CheckPixels returns a *LimitError if a canvas or frame of width by height pixels exceeds
+// Limits.MaxPixels.
+func (o *Options) CheckPixels(width, height int) error {
+	pixels := int64(width) * int64(height)
+	if width > 0 && pixels/int64(width) != int64(height) {
+		// the product overflowed, which any limit is below
+		pixels = math.MaxInt64
+	}
+	return check("MaxPixels", pixels, o.limits().MaxPixels)
+}
+
+// CheckFrames returns a *LimitError if scanning frames frames exceeds Limits.MaxFrames.
+func (o *Options) CheckFrames(frames int) error {
+	return check("MaxFrames", int64(frames), int64(o.limits().MaxFrames))
+}
+
+// CheckChunkSize returns a *LimitError if a chunk or block of size bytes exceeds
+// Limits.MaxChunkSize.
+func (o *Options) CheckChunkSize(size int64) error {
+	return check("MaxChunkSize", size, o.limits().MaxChunkSize)
+}
+
+// LimitReader returns a reader that fails with a *LimitError once more than Limits.MaxBytes have
+// been read from r. It returns r itself if there is no such limit. If r implements io.Seeker, so does
+// the returned reader, which also fails to seek past the limit. If r implements io.ByteReader, so
+// does the returned reader, so parsers do not buffer past the image.
+func (o *Options) LimitReader(r io.Reader) io.Reader {
+	max := o.limits().MaxBytes
+	if max <= 0 {
+		return r
+	}
+	l := &limitReader{r: r, remaining: max, max: max}
+	br, isByteReader := r.(io.ByteReader)
+	if s, ok := r.(io.Seeker); ok {
+		if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+			if isByteReader {
+				return &limitByteSeeker{&limitSeeker{l, s, start}, limitBytes{l, br}}
+			}
+			return &limitSeeker{l, s, start}
+		}
+	}
+	if isByteReader {
+		return &limitByteReader{l, limitBytes{l, br}}
+	}
+	return l
+}
+
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if l.remaining <= 0 {
+		// the input may end exactly at the limit, only fail if there is more of it
+		n, err := l.r.Read(p[:1])
+		if n > 0 {
+			return 0, &LimitError{Limit: "MaxBytes", Value: l.max + 1, Max: l.max}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// limitSeeker is a limitReader that can seek, as long as it does not seek past the limit.
+type limitSeeker struct {
+	*limitReader
+	s     io.Seeker
+	start int64
+}
+
+func (l *limitSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		offset += l.start + l.max - l.remaining
+		whence = io.SeekStart
+	}
+	if whence == io.SeekStart && offset-l.start > l.max {
+		return 0, &LimitError{Limit: "MaxBytes", Value: offset - l.start, Max: l.max}
+	}
+	pos, err := l.s.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	l.remaining = l.max - (pos - l.start)
+	return pos, nil
+}
+
+// limitBytes reads single bytes for a limitReader whose source is an io.ByteReader.
+type limitBytes struct {
+	l  *limitReader
+	br io.ByteReader
+}
+
+func (b limitBytes) ReadByte() (byte, error) {
+	c, err := b.br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b.l.remaining <= 0 {
+		return 0, &LimitError{Limit: "MaxBytes", Value: b.l.max + 1, Max: b.l.max}
+	}
+	b.l.remaining--
+	return c, nil
+}
+
+type limitByteReader struct {
+	*limitReader
+	limitBytes
+}
+
+type limitByteSeeker struct {
+	*limitSeeker
+	limitBytes
+}
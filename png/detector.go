@@ -0,0 +1,96 @@
+package png
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/internal/incremental"
+)
+
+// NewIncrementalDetector returns a deanimator.SkippingDetector verifying the animation of a PNG
+// written to it like VerifyAnimated does. Chunk data is skipped as it is written, only chunk headers
+// and the "acTL" chunk are buffered. It enforces the MaxChunkSize limit of opts.
+func NewIncrementalDetector(opts *deanimator.Options) deanimator.IncrementalDetector {
+	d := &detector{opts: opts}
+	d.p = incremental.New(len(pngHeader), d.header)
+	return d
+}
+
+type detector struct {
+	p      *incremental.Parser
+	opts   *deanimator.Options
+	result *deanimator.Detection
+	frames uint32
+	fctls  int
+}
+
+func (d *detector) Write(b []byte) (*deanimator.Detection, error) {
+	if err := d.p.Write(b); err != nil {
+		return nil, err
+	}
+	return d.result, nil
+}
+
+func (d *detector) Skip() int64 { return d.p.TakeSkip() }
+func (d *detector) Need() int   { return d.p.Need() }
+
+func (d *detector) static(reason string) error {
+	d.result = &deanimator.Detection{Format: "png", State: deanimator.Static, Reason: reason}
+	return nil
+}
+
+func (d *detector) header(b []byte) error {
+	if string(b) != pngHeader {
+		return parseError(0, "", errSignature)
+	}
+	d.p.Read(8, d.chunkHeader)
+	return nil
+}
+
+func (d *detector) chunkHeader(b []byte) error {
+	offset := d.p.Offset()
+	chunkLength := binary.BigEndian.Uint32(b[:4])
+	chunkType := string(b[4:])
+	if err := d.opts.CheckChunkSize(int64(chunkLength)); err != nil {
+		return parseError(offset, chunkType, err)
+	}
+
+	switch {
+	case chunkType == actl:
+		if err := checkFixedChunk(chunkType, chunkLength); err != nil {
+			return parseError(offset, chunkType, err)
+		}
+		// +4 to also read CRC
+		d.p.Read(int(chunkLength)+4, d.actl)
+		return nil
+	case chunkType == idat && d.frames == 0:
+		return d.static("IDAT precedes any acTL")
+	case chunkType == fctl:
+		d.fctls++
+		if d.fctls == 2 {
+			d.result = &deanimator.Detection{
+				Format: "png",
+				State:  deanimator.Animated,
+				Reason: fmt.Sprintf("acTL declares %d frames and a second fcTL follows", d.frames),
+			}
+			return nil
+		}
+	case chunkType == iend:
+		return d.static(fmt.Sprintf("acTL declares %d frames but only %d fcTL precedes IEND", d.frames, d.fctls))
+	}
+
+	// +4 to also skip CRC
+	d.p.Skip(int64(chunkLength) + 4)
+	d.p.Read(8, d.chunkHeader)
+	return nil
+}
+
+func (d *detector) actl(b []byte) error {
+	d.frames = binary.BigEndian.Uint32(b[0:4])
+	if d.frames < 2 {
+		return d.static(fmt.Sprintf("acTL declares only %d frame(s)", d.frames))
+	}
+	d.p.Read(8, d.chunkHeader)
+	return nil
+}
@@ -0,0 +1,295 @@
+package png
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	gopng "image/png"
+	"io"
+	"math"
+	"time"
+
+	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/internal/animation"
+)
+
+const (
+	fdat = "fdAT"
+	plte = "PLTE"
+)
+
+// RenderFrame writes frame index of the APNG in r to w as a PNG, composited the way browsers
+// display it: frames are drawn over the frames before them by their "fcTL" blend operation, which
+// they dispose of by their dispose operation. The first frame has index 0, a default image that is
+// not part of the animation is skipped. A still PNG only has frame 0.
+func RenderFrame(r io.Reader, w io.Writer, index int) error {
+	return RenderFrameContext(context.Background(), r, w, index, nil)
+}
+
+// RenderFrameContext is like RenderFrame, but checks ctx between chunks and returns ctx.Err() once
+// it is done. Frames are decoded with DecodeFunc. It enforces the limits of opts, counting "fcTL"
+// chunks against MaxFrames. An index past the last frame returns an error wrapping
+// deanimator.ErrFrameIndex.
+func RenderFrameContext(ctx context.Context, r io.Reader, w io.Writer, index int, opts *deanimator.Options) error {
+	p, err := newPlayer(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	if err := p.skipTo(index); err != nil {
+		return err
+	}
+	return gopng.Encode(w, p.canvas.Image())
+}
+
+// frameControl is an "fcTL" chunk, which places a frame on the canvas.
+type frameControl struct {
+	width, height, x, y int
+	delay               time.Duration
+	dispose             animation.Disposal
+	blend               bool
+}
+
+func parseFrameControl(data []byte) frameControl {
+	fc := frameControl{
+		width:  int(binary.BigEndian.Uint32(data[4:8])),
+		height: int(binary.BigEndian.Uint32(data[8:12])),
+		x:      int(binary.BigEndian.Uint32(data[12:16])),
+		y:      int(binary.BigEndian.Uint32(data[16:20])),
+		delay:  frameDelay(data),
+		blend:  data[25] == 1,
+	}
+	switch data[24] {
+	case 1:
+		fc.dispose = animation.DisposeBackground
+	case 2:
+		fc.dispose = animation.DisposePrevious
+	}
+	return fc
+}
+
+// WalkFrames calls fn with each frame of the APNG in r, composited onto the canvas like RenderFrame
+// does. m is only valid until fn returns, and an error returned by fn stops the walk and is
+// returned as is.
+func WalkFrames(r io.Reader, fn func(m image.Image) error) error {
+	return WalkFramesContext(context.Background(), r, nil, fn)
+}
+
+// WalkFramesContext is like WalkFrames, but stops with ctx.Err() once ctx is done. It enforces the
+// limits of opts like RenderFrameContext.
+func WalkFramesContext(ctx context.Context, r io.Reader, opts *deanimator.Options, fn func(m image.Image) error) error {
+	p, err := newPlayer(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := p.next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(p.canvas.Image()); err != nil {
+			return err
+		}
+	}
+}
+
+// player composites the frames of an APNG onto its canvas one at a time.
+type player struct {
+	ctx  context.Context
+	r    io.Reader
+	opts *deanimator.Options
+
+	offset   int64
+	ihdr     []byte
+	chunks   bytes.Buffer // chunks needed to decode frames, like "PLTE" and "tRNS"
+	animated bool
+	fctls    int
+	canvas   *animation.Canvas
+
+	control *frameControl // of the frame whose data is being read
+	data    bytes.Buffer
+	frames  int // the number of frames drawn
+	ended   bool
+}
+
+func newPlayer(ctx context.Context, r io.Reader, opts *deanimator.Options) (*player, error) {
+	r = opts.LimitReader(r)
+	header := make([]byte, len(pngHeader))
+	if err := readFull(r, header); err != nil {
+		return nil, parseError(0, "", err)
+	}
+	if string(header) != pngHeader {
+		return nil, parseError(0, "", errSignature)
+	}
+	return &player{ctx: ctx, r: r, opts: opts, offset: int64(len(pngHeader))}, nil
+}
+
+// next draws the next frame onto the canvas and returns its "fcTL" chunk. It returns io.EOF after
+// the last frame.
+func (p *player) next() (*frameControl, error) {
+	if p.ended {
+		return nil, io.EOF
+	}
+	chunkHeader := make([]byte, 8)
+	for {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := readFull(p.r, chunkHeader); err != nil {
+			return nil, parseError(p.offset, "", err)
+		}
+		chunkLength := binary.BigEndian.Uint32(chunkHeader[:4])
+		chunkType := string(chunkHeader[4:])
+		if err := p.opts.CheckChunkSize(int64(chunkLength)); err != nil {
+			return nil, parseError(p.offset, chunkType, err)
+		}
+		if chunkType != ihdr && p.ihdr == nil {
+			return nil, parseError(p.offset, chunkType, fmt.Errorf("png missing IHDR chunk: %w", deanimator.ErrMalformed))
+		}
+
+		offset := p.offset
+		p.offset += 12 + int64(chunkLength)
+		var finished *frameControl
+		var err error
+		switch chunkType {
+		case ihdr, actl, fctl:
+			var data []byte
+			data, err = readFixedChunk(p.r, chunkType, chunkLength)
+			if err != nil {
+				break
+			}
+			switch chunkType {
+			case ihdr:
+				p.ihdr = data[:13]
+				width := int(binary.BigEndian.Uint32(data[0:4]))
+				height := int(binary.BigEndian.Uint32(data[4:8]))
+				if err = p.opts.CheckPixels(width, height); err != nil {
+					break
+				}
+				// the spec limits both to 31 bits, and the 4 bytes a pixel of the canvas takes have
+				// to be counted by an int
+				if width <= 0 || height <= 0 || width > math.MaxInt32 || height > math.MaxInt32 ||
+					int64(width)*int64(height) > math.MaxInt/4 {
+					err = fmt.Errorf("png invalid size %dx%d: %w", width, height, deanimator.ErrMalformed)
+					break
+				}
+				p.canvas = animation.NewCanvas(width, height, color.Transparent)
+			case actl:
+				p.animated = true
+			case fctl:
+				p.fctls++
+				if err = p.opts.CheckFrames(p.fctls); err != nil {
+					break
+				}
+				fc := parseFrameControl(data)
+				if fc.x+fc.width > p.canvas.Image().Rect.Dx() || fc.y+fc.height > p.canvas.Image().Rect.Dy() {
+					err = fmt.Errorf("frame outside of the canvas: %w", deanimator.ErrMalformed)
+					break
+				}
+				finished, p.control = p.control, &fc
+			}
+		case plte, trns:
+			p.chunks.Write(chunkHeader)
+			err = copyN(&p.chunks, p.r, int64(chunkLength)+4)
+		case idat:
+			if p.control == nil && !p.animated {
+				// a still image is a single frame covering the canvas
+				bounds := p.canvas.Image().Rect
+				p.control = &frameControl{width: bounds.Dx(), height: bounds.Dy()}
+			}
+			if p.control == nil {
+				// the default image is not part of the animation
+				err = skip(p.r, int64(chunkLength)+4)
+				break
+			}
+			if err = copyN(&p.data, p.r, int64(chunkLength)); err == nil {
+				err = skip(p.r, 4)
+			}
+		case fdat:
+			if p.control == nil || chunkLength < 4 {
+				err = fmt.Errorf("fdAT chunk without a frame: %w", deanimator.ErrMalformed)
+				break
+			}
+			// skip the sequence number
+			if err = skip(p.r, 4); err != nil {
+				break
+			}
+			if err = copyN(&p.data, p.r, int64(chunkLength)-4); err == nil {
+				err = skip(p.r, 4)
+			}
+		case iend:
+			if p.control == nil {
+				return nil, io.EOF
+			}
+			finished, p.control = p.control, nil
+			p.ended = true
+		default:
+			// +4 to also skip CRC
+			err = skip(p.r, int64(chunkLength)+4)
+		}
+		if err != nil {
+			return nil, parseError(offset, chunkType, err)
+		}
+
+		if finished != nil {
+			if err := p.draw(finished); err != nil {
+				return nil, parseError(offset, chunkType, err)
+			}
+			return finished, nil
+		}
+	}
+}
+
+// draw decodes the data of the frame controlled by fc and draws it onto the canvas.
+func (p *player) draw(fc *frameControl) error {
+	// a frame is decoded as a PNG of its own size, with the chunks of the image it is part of
+	frame := bytes.NewBuffer([]byte(pngHeader))
+	header := append([]byte{}, p.ihdr...)
+	binary.BigEndian.PutUint32(header[0:4], uint32(fc.width))
+	binary.BigEndian.PutUint32(header[4:8], uint32(fc.height))
+	writeChunk(frame, ihdr, header)
+	frame.Write(p.chunks.Bytes())
+	writeChunk(frame, idat, p.data.Bytes())
+	frame.Write(iendChunk)
+	p.data.Reset()
+
+	m, err := DecodeFunc(frame)
+	if err != nil {
+		return err
+	}
+	p.canvas.Draw(m, image.Point{X: fc.x, Y: fc.y}, fc.blend, fc.dispose)
+	p.frames++
+	return nil
+}
+
+// writeChunk writes a chunk of the given type and data to b.
+func writeChunk(b *bytes.Buffer, chunkType string, data []byte) {
+	var word [4]byte
+	binary.BigEndian.PutUint32(word[:], uint32(len(data)))
+	b.Write(word[:])
+	start := b.Len()
+	b.WriteString(chunkType)
+	b.Write(data)
+	binary.BigEndian.PutUint32(word[:], crc32.ChecksumIEEE(b.Bytes()[start:]))
+	b.Write(word[:])
+}
+
+// skipTo draws the frames up to frame index.
+func (p *player) skipTo(index int) error {
+	if index < 0 {
+		return fmt.Errorf("png: frame %d: %w", index, deanimator.ErrFrameIndex)
+	}
+	for p.frames <= index {
+		if _, err := p.next(); err == io.EOF {
+			return fmt.Errorf("png: frame %d of %d frames: %w", index, p.frames, deanimator.ErrFrameIndex)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,30 +2,79 @@
 package png
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
 	gopng "image/png"
 	"io"
+	"time"
 	"unicode"
 
 	"github.com/slackhq/deanimator"
-	"github.com/slackhq/deanimator/window"
 )
 
 const pngHeader = "\x89PNG\r\n\x1a\n"
 
 const (
+	ihdr = "IHDR"
 	idat = "IDAT"
 	iend = "IEND"
 	actl = "acTL"
 	fctl = "fcTL"
+	trns = "tRNS"
 )
 
 var (
-	errUnderflow = errors.New("png buffer underflow")
+	errChecksum  = fmt.Errorf("png chunk checksum mismatch: %w", deanimator.ErrMalformed)
+	errSignature = fmt.Errorf("invalid png file: %w", deanimator.ErrMalformed)
 	iendChunk    = []byte{0, 0, 0, 0, 'I', 'E', 'N', 'D', 0xAE, 0x42, 0x60, 0x82}
 )
 
+// parseError wraps err in a *deanimator.ParseError for the chunk starting at offset. An EOF is
+// reported as an underflow of 1 byte.
+func parseError(offset int64, chunk string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = underflow(1)
+	}
+	return &deanimator.ParseError{Format: "png", Offset: offset, Chunk: chunk, Err: err}
+}
+
+// underflow returns the error for input that ended need bytes short.
+func underflow(need int64) error {
+	return fmt.Errorf("png buffer underflow: %w", deanimator.NeedMoreData(need))
+}
+
+// readFull is like io.ReadFull, but reports input that ends early as an underflow of the rest of b.
+func readFull(r io.Reader, b []byte) error {
+	n, err := io.ReadFull(r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return underflow(int64(len(b) - n))
+	}
+	return err
+}
+
+// skip is like copyN to io.Discard, but seeks over the bytes if r implements io.Seeker.
+func skip(r io.Reader, n int64) error {
+	skipped, err := deanimator.Discard(r, n)
+	if err == io.EOF {
+		return underflow(n - skipped)
+	}
+	return err
+}
+
+// copyN is like io.CopyN, but reports input that ends early as an underflow of the rest of the n
+// bytes.
+func copyN(w io.Writer, r io.Reader, n int64) error {
+	written, err := io.CopyN(w, r, n)
+	if err == io.EOF {
+		return underflow(n - written)
+	}
+	return err
+}
+
 //DecodeFunc lets you override the built-in PNG package decode if desired.
 var DecodeFunc = gopng.Decode
 
@@ -36,32 +85,34 @@ var DecodeFunc = gopng.Decode
 // IsAnimated returns true if the reader is an animated PNG (APNG). A false result with no error
 // indicates the buffer definitively contains a normal PNG.
 func IsAnimated(r io.Reader) (bool, error) {
-	wr := window.NewReader(r, 8)
-	chunkHeader := make([]byte, 8)
-
-	_, err := wr.ReadWindow(chunkHeader)
-	if err != nil {
-		return false, err
-	}
+	return IsAnimatedContext(context.Background(), r, nil)
+}
 
-	if string(chunkHeader) != pngHeader {
-		return false, errors.New("invalid png file")
+// IsAnimatedContext is like IsAnimated, but checks ctx between chunks and returns ctx.Err() once it
+// is done. Chunk data is seeked over when r implements io.Seeker. It enforces the MaxBytes and
+// MaxChunkSize limits of opts.
+func IsAnimatedContext(ctx context.Context, r io.Reader, opts *deanimator.Options) (bool, error) {
+	r = opts.LimitReader(r)
+	header := make([]byte, len(pngHeader))
+	if err := readFull(r, header); err != nil {
+		return false, parseError(0, "", err)
 	}
-	// skip the rest of the header
-	_, err = wr.Skip(7)
-	if err != nil {
-		return false, err
+	if string(header) != pngHeader {
+		return false, parseError(0, "", errSignature)
 	}
 
+	chunkHeader := make([]byte, 8)
+	offset := int64(len(pngHeader))
 	for {
-		_, err = wr.ReadWindow(chunkHeader)
-		if err == io.EOF {
-			// EOF can be expected here i think on a chunk boundary?
-			return false, nil
-		} else if err != nil {
+		if err := ctx.Err(); err != nil {
 			return false, err
 		}
 
+		// a PNG ends with an "IEND" chunk, so an EOF here means the image was truncated
+		if err := readFull(r, chunkHeader); err != nil {
+			return false, parseError(offset, "", err)
+		}
+
 		chunkType := string(chunkHeader[4:])
 		switch chunkType {
 		case actl:
@@ -71,87 +122,499 @@ func IsAnimated(r io.Reader) (bool, error) {
 			return false, nil
 		}
 
-		// skip the rest of the header
-		_, err = wr.Skip(7)
-		if err != nil {
-			return false, err
-		}
-
-		// skip reads for the chunk data
 		chunkLength := binary.BigEndian.Uint32(chunkHeader[:4])
-		_, err = wr.Skip(int(chunkLength))
-		if err != nil {
-			return false, err
+		if err := opts.CheckChunkSize(int64(chunkLength)); err != nil {
+			return false, parseError(offset, chunkType, err)
 		}
-
-		// skip crc
-		_, err = wr.Skip(4)
-		if err != nil {
-			return false, err
+		// +4 to also skip CRC
+		if err := skip(r, int64(chunkLength)+4); err != nil {
+			return false, parseError(offset, chunkType, err)
 		}
+
+		offset += 12 + int64(chunkLength)
+	}
+}
+
+// InputFormat returns "apng" for an animated PNG, and "png" otherwise, like the InputFormat of the
+// Result of RenderFirstFrameContext. It reads like IsAnimatedContext.
+func InputFormat(ctx context.Context, r io.Reader, opts *deanimator.Options) (string, error) {
+	animated, err := IsAnimatedContext(ctx, r, opts)
+	if err != nil {
+		return "", err
+	} else if animated {
+		return "apng", nil
 	}
+	return "png", nil
 }
 
 // RenderFirstFrame extracts the first frame from an animated PNG (APNG). If the image is not
 // complete, it scans the image, stripping non-public chunks while checking wether a complete
 // default image is available (e.g. the start of an "fcTL" chunk after 1 or more "IDAT" chunks).
 // If the complete default image can be extracted, it terminates the image with an "IEND" chunk.
+// A PNG without an "acTL" chunk before its image data returns deanimator.ErrNotAnimated, and
+// nothing is written to dst: output is held back until the "fcTL" chunk of the default image is
+// found. An APNG whose only frame is its default image has it written as is. When no "fcTL" chunk
+// precedes the default image it is not part of the animation, and the first frame after it is
+// written instead, drawn onto the canvas like RenderFrame does.
 func RenderFirstFrame(src io.Reader, dst io.Writer) error {
+	_, err := RenderFirstFrameContext(context.Background(), src, dst, nil)
+	return err
+}
+
+// RenderFirstFrameContext is like RenderFirstFrame, but checks ctx between chunks and returns
+// ctx.Err() once it is done. The result reports the input as "apng" if it has an "acTL" chunk. It
+// enforces the MaxBytes, MaxChunkSize and MaxPixels limits of opts.
+func RenderFirstFrameContext(ctx context.Context, src io.Reader, dst io.Writer, opts *deanimator.Options) (*deanimator.Result, error) {
+	src = opts.LimitReader(src)
+
 	// copy header to dst
-	_, err := io.CopyN(dst, src, 8)
+	header := make([]byte, len(pngHeader))
+	err := readFull(src, header)
 	if err != nil {
-		return err
+		return nil, parseError(0, "", err)
 	}
+	if string(header) != pngHeader {
+		return nil, parseError(0, "", errSignature)
+	}
+	// hold the output back until an "fcTL" chunk shows the default image is the first frame
+	held := bytes.NewBuffer(header)
+	// replay also holds the "acTL" chunk, to draw the first frame if it is not the default image
+	replay := bytes.NewBuffer(append([]byte{}, header...))
+	var out io.Writer = io.MultiWriter(held, replay)
+	flushed := false
 
+	res := &deanimator.Result{
+		InputFormat:  "png",
+		OutputFormat: "png",
+		MIMEType:     "image/png",
+	}
 	chunkHeader := make([]byte, 8)
 	sawIDAT := false
 	completeIDAT := false
+	offset := int64(len(pngHeader))
 	for {
-		_, err = src.Read(chunkHeader)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err = readFull(src, chunkHeader)
 		if err != nil {
-			return err
+			return nil, parseError(offset, "", err)
 		}
 
 		chunkLength := binary.BigEndian.Uint32(chunkHeader[:4])
 		chunkType := string(chunkHeader[4:])
+		if err := opts.CheckChunkSize(int64(chunkLength)); err != nil {
+			return nil, parseError(offset, chunkType, err)
+		}
 
+		animated := res.InputFormat == "apng"
 		if chunkType == fctl && sawIDAT {
 			completeIDAT = true
 			break
+		} else if (chunkType == idat || chunkType == iend) && !animated {
+			// "IDAT" or "IEND" before "acTL" means this is not an animated png
+			return nil, deanimator.ErrNotAnimated
+		} else if chunkType == idat && !flushed {
+			// the default image is not part of the animation, replay the chunks read so far, which
+			// are all that is needed to decode the frames, to draw the first frame after it
+			r := io.MultiReader(replay, bytes.NewReader(chunkHeader), src)
+			if err := RenderFrameContext(ctx, r, dst, 0, opts); err != nil {
+				return nil, err
+			}
+			return res, nil
 		} else if chunkType == idat {
 			sawIDAT = true
+		} else if chunkType == iend {
+			if !sawIDAT {
+				return nil, parseError(offset, chunkType, fmt.Errorf("png missing IDAT chunk: %w", deanimator.ErrMalformed))
+			}
+			// the default image is the only frame
+			completeIDAT = true
+			break
 		}
 
-		copyTo := io.Discard
+		chunkData := src
+		if chunkType == ihdr || chunkType == actl {
+			// read the chunk to describe the image in the result before passing it on
+			data, err := readFixedChunk(src, chunkType, chunkLength)
+			if err != nil {
+				return nil, parseError(offset, chunkType, err)
+			}
+			if chunkType == ihdr {
+				res.Width = int(binary.BigEndian.Uint32(data[0:4]))
+				res.Height = int(binary.BigEndian.Uint32(data[4:8]))
+				if err := opts.CheckPixels(res.Width, res.Height); err != nil {
+					return nil, parseError(offset, chunkType, err)
+				}
+			} else {
+				res.InputFormat = "apng"
+				res.Frames = int(binary.BigEndian.Uint32(data[0:4]))
+				replay.Write(chunkHeader)
+				replay.Write(data)
+			}
+			chunkData = bytes.NewReader(data)
+		}
 
 		if unicode.IsUpper(rune(chunkType[1])) {
 			// public chunk, just copy through
-			_, err = dst.Write(chunkHeader)
+			_, err = out.Write(chunkHeader)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			copyTo = dst
 
+			// +4 to also copy CRC
+			err = copyN(out, chunkData, int64(chunkLength)+4)
+		} else {
+			err = skip(chunkData, int64(chunkLength)+4)
 		}
-
-		// +4 to also copy CRC
-		_, err = io.CopyN(copyTo, src, int64(chunkLength)+4)
 		if err != nil {
-			return err
+			return nil, parseError(offset, chunkType, err)
 		}
+		if chunkType == fctl && animated && !flushed {
+			// the default image is the first frame, pass the held output on and stream the rest
+			if _, err := dst.Write(held.Bytes()); err != nil {
+				return nil, err
+			}
+			out = dst
+			flushed = true
+		}
+
+		offset += 12 + int64(chunkLength)
 	}
 	if !completeIDAT {
-		return errUnderflow
+		return nil, parseError(offset, "", underflow(1))
 	}
 
 	_, err = dst.Write(iendChunk)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return res, nil
+}
+
+// FirstFrame decodes the first frame of an APNG with DecodeFunc, which is its default image unless
+// the default image is not part of the animation. A still PNG is its own first frame. Like
+// RenderFirstFrame, an APNG does not need to be complete.
+func FirstFrame(src io.Reader) (image.Image, error) {
+	return FirstFrameContext(context.Background(), src, nil)
+}
+
+// FirstFrameContext is like FirstFrame, but checks ctx between chunks and returns ctx.Err() once it
+// is done. It enforces the limits of opts like RenderFirstFrameContext.
+func FirstFrameContext(ctx context.Context, src io.Reader, opts *deanimator.Options) (image.Image, error) {
+	src = opts.LimitReader(src)
+	read := bytes.NewBuffer([]byte{})
+	frame := bytes.NewBuffer([]byte{})
+	_, err := RenderFirstFrameContext(ctx, io.TeeReader(src, read), frame, opts)
+	if err == deanimator.ErrNotAnimated {
+		// a still image is decoded as it is
+		frame = read
+		_, err = io.Copy(frame, deanimator.ContextReader(ctx, src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return DecodeFunc(frame)
+}
+
+// fixedChunkLengths are the data lengths of the chunks read by readFixedChunk.
+var fixedChunkLengths = map[string]uint32{
+	ihdr: 13,
+	actl: 8,
+	fctl: 26,
+}
+
+// checkFixedChunk returns an error if a chunk whose length is fixed by the specification has another
+// length.
+func checkFixedChunk(chunkType string, chunkLength uint32) error {
+	if chunkLength != fixedChunkLengths[chunkType] {
+		return fmt.Errorf("invalid chunk length %d: %w", chunkLength, deanimator.ErrMalformed)
+	}
 	return nil
 }
 
+// readFixedChunk reads the data and CRC of a chunk whose length is fixed by the specification.
+func readFixedChunk(r io.Reader, chunkType string, chunkLength uint32) ([]byte, error) {
+	if err := checkFixedChunk(chunkType, chunkLength); err != nil {
+		return nil, err
+	}
+	data := make([]byte, chunkLength+4)
+	if err := readFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// VerifyAnimated reports the PNG in r as animated if its "acTL" chunk declares more than one frame
+// and a second "fcTL" chunk follows, so the frames exist. It reads up to the second "fcTL" chunk.
+// It enforces the MaxBytes and MaxChunkSize limits of opts.
+func VerifyAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Detection, error) {
+	r = opts.LimitReader(r)
+	header := make([]byte, len(pngHeader))
+	if err := readFull(r, header); err != nil {
+		return nil, parseError(0, "", err)
+	}
+	if string(header) != pngHeader {
+		return nil, parseError(0, "", errSignature)
+	}
+
+	static := func(reason string) (*deanimator.Detection, error) {
+		return &deanimator.Detection{Format: "png", State: deanimator.Static, Reason: reason}, nil
+	}
+	frames := uint32(0)
+	fctls := 0
+	chunkHeader := make([]byte, 8)
+	offset := int64(len(pngHeader))
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := readFull(r, chunkHeader); err != nil {
+			return nil, parseError(offset, "", err)
+		}
+		chunkLength := binary.BigEndian.Uint32(chunkHeader[:4])
+		chunkType := string(chunkHeader[4:])
+		if err := opts.CheckChunkSize(int64(chunkLength)); err != nil {
+			return nil, parseError(offset, chunkType, err)
+		}
+
+		switch {
+		case chunkType == actl:
+			data, err := readFixedChunk(r, chunkType, chunkLength)
+			if err != nil {
+				return nil, parseError(offset, chunkType, err)
+			}
+			frames = binary.BigEndian.Uint32(data[0:4])
+			if frames < 2 {
+				return static(fmt.Sprintf("acTL declares only %d frame(s)", frames))
+			}
+			offset += 12 + int64(chunkLength)
+			continue
+		case chunkType == idat && frames == 0:
+			return static("IDAT precedes any acTL")
+		case chunkType == fctl:
+			fctls++
+			if fctls == 2 {
+				return &deanimator.Detection{
+					Format: "png",
+					State:  deanimator.Animated,
+					Reason: fmt.Sprintf("acTL declares %d frames and a second fcTL follows", frames),
+				}, nil
+			}
+		case chunkType == iend:
+			return static(fmt.Sprintf("acTL declares %d frames but only %d fcTL precedes IEND", frames, fctls))
+		}
+
+		// +4 to also skip CRC
+		if err := skip(r, int64(chunkLength)+4); err != nil {
+			return nil, parseError(offset, chunkType, err)
+		}
+		offset += 12 + int64(chunkLength)
+	}
+}
+
+// Inspect reads the chunks of the PNG in r up to "IEND" without decoding pixel data, and describes
+// it. The animation of an APNG is described by its "acTL" and "fcTL" chunks. It enforces the
+// MaxBytes and MaxChunkSize limits of opts, and counts "fcTL" chunks against MaxFrames.
+func Inspect(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Info, error) {
+	r = opts.LimitReader(r)
+	header := make([]byte, len(pngHeader))
+	if err := readFull(r, header); err != nil {
+		return nil, parseError(0, "", err)
+	}
+	if string(header) != pngHeader {
+		return nil, parseError(0, "", errSignature)
+	}
+
+	info := &deanimator.Info{Format: "png", Frames: 1, LoopCount: 1}
+	animated := false
+	var delays []time.Duration
+	chunkHeader := make([]byte, 8)
+	offset := int64(len(pngHeader))
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := readFull(r, chunkHeader); err != nil {
+			return nil, parseError(offset, "", err)
+		}
+		chunkLength := binary.BigEndian.Uint32(chunkHeader[:4])
+		chunkType := string(chunkHeader[4:])
+		if err := opts.CheckChunkSize(int64(chunkLength)); err != nil {
+			return nil, parseError(offset, chunkType, err)
+		}
+
+		var err error
+		switch chunkType {
+		case ihdr, actl, fctl:
+			var data []byte
+			data, err = readFixedChunk(r, chunkType, chunkLength)
+			if err != nil {
+				break
+			}
+			switch chunkType {
+			case ihdr:
+				info.Width = int(binary.BigEndian.Uint32(data[0:4]))
+				info.Height = int(binary.BigEndian.Uint32(data[4:8]))
+				// color types 4 and 6 have an alpha channel
+				info.HasAlpha = info.HasAlpha || data[9]&4 != 0
+			case actl:
+				animated = true
+				info.Frames = int(binary.BigEndian.Uint32(data[0:4]))
+				info.LoopCount = int(binary.BigEndian.Uint32(data[4:8]))
+			case fctl:
+				if err = opts.CheckFrames(len(delays) + 1); err != nil {
+					break
+				}
+				delays = append(delays, frameDelay(data))
+			}
+		case iend:
+			if animated {
+				info.Delays = delays
+				for _, delay := range delays {
+					info.Duration += delay
+				}
+			}
+			return info, nil
+		default:
+			if chunkType == trns {
+				info.HasAlpha = true
+			}
+			// +4 to also skip CRC
+			err = skip(r, int64(chunkLength)+4)
+		}
+		if err != nil {
+			return nil, parseError(offset, chunkType, err)
+		}
+		offset += 12 + int64(chunkLength)
+	}
+}
+
+// frameDelay returns the delay of the frame described by the data of an "fcTL" chunk.
+func frameDelay(fctlData []byte) time.Duration {
+	num := time.Duration(binary.BigEndian.Uint16(fctlData[20:22]))
+	den := time.Duration(binary.BigEndian.Uint16(fctlData[22:24]))
+	if den == 0 {
+		// a denominator of 0 means 100ths of a second
+		den = 100
+	}
+	return num * time.Second / den
+}
+
+// Validate reads the whole PNG in r and returns an error if its chunk structure is not well formed.
+// It checks the signature, that "IHDR" is the first chunk, every chunk CRC and that the image ends
+// with an "IEND" chunk. Pixel data is not decoded. It enforces the MaxBytes and MaxChunkSize limits
+// of opts, and counts "fcTL" chunks against MaxFrames.
+func Validate(ctx context.Context, r io.Reader, opts *deanimator.Options) error {
+	r = opts.LimitReader(r)
+	header := make([]byte, 8)
+	if err := readFull(r, header); err != nil {
+		return parseError(0, "", err)
+	}
+	if string(header) != pngHeader {
+		return parseError(0, "", errSignature)
+	}
+
+	crc := crc32.NewIEEE()
+	chunkHeader := make([]byte, 8)
+	checksum := make([]byte, 4)
+	offset := int64(len(pngHeader))
+	frames := 0
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := readFull(r, chunkHeader); err != nil {
+			return parseError(offset, "", err)
+		}
+		chunkLength := binary.BigEndian.Uint32(chunkHeader[:4])
+		chunkType := string(chunkHeader[4:])
+		if first && chunkType != ihdr {
+			return parseError(offset, chunkType, fmt.Errorf("png missing IHDR chunk: %w", deanimator.ErrMalformed))
+		}
+		if err := opts.CheckChunkSize(int64(chunkLength)); err != nil {
+			return parseError(offset, chunkType, err)
+		}
+		if chunkType == fctl {
+			frames++
+			if err := opts.CheckFrames(frames); err != nil {
+				return parseError(offset, chunkType, err)
+			}
+		}
+
+		crc.Reset()
+		crc.Write(chunkHeader[4:])
+		if err := copyN(crc, r, int64(chunkLength)); err != nil {
+			return parseError(offset, chunkType, err)
+		}
+		if err := readFull(r, checksum); err != nil {
+			return parseError(offset, chunkType, err)
+		}
+		if binary.BigEndian.Uint32(checksum) != crc.Sum32() {
+			return parseError(offset, chunkType, errChecksum)
+		}
+
+		if chunkType == iend {
+			return nil
+		}
+		offset += 12 + int64(chunkLength)
+	}
+}
+
+// Format implements deanimator.Format, deanimator.AnimationVerifier, deanimator.FirstFrameDecoder,
+// deanimator.FrameRenderer, deanimator.FrameWalker, deanimator.Inspector,
+// deanimator.IncrementalVerifier, deanimator.InputFormatter and deanimator.Validator for PNG and
+// APNG images. It is registered to deanimator.DefaultRegistry when this package is imported.
+type Format struct{}
+
+func (Format) Name() string  { return "png" }
+func (Format) Magic() string { return pngHeader }
+
+func (Format) IsAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (bool, error) {
+	return IsAnimatedContext(ctx, r, opts)
+}
+
+func (Format) RenderFirstFrame(ctx context.Context, r io.Reader, w io.Writer, opts *deanimator.Options) (*deanimator.Result, error) {
+	return RenderFirstFrameContext(ctx, r, w, opts)
+}
+
+func (Format) FirstFrame(ctx context.Context, r io.Reader, opts *deanimator.Options) (image.Image, error) {
+	return FirstFrameContext(ctx, r, opts)
+}
+
+func (Format) RenderFrame(ctx context.Context, r io.Reader, w io.Writer, index int, opts *deanimator.Options) error {
+	return RenderFrameContext(ctx, r, w, index, opts)
+}
+
+func (Format) WalkFrames(ctx context.Context, r io.Reader, opts *deanimator.Options, fn func(m image.Image) error) error {
+	return WalkFramesContext(ctx, r, opts, fn)
+}
+
+func (Format) VerifyAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Detection, error) {
+	return VerifyAnimated(ctx, r, opts)
+}
+
+func (Format) Inspect(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Info, error) {
+	return Inspect(ctx, r, opts)
+}
+
+func (Format) NewIncrementalDetector(opts *deanimator.Options) deanimator.IncrementalDetector {
+	return NewIncrementalDetector(opts)
+}
+
+func (Format) InputFormat(ctx context.Context, r io.Reader, opts *deanimator.Options) (string, error) {
+	return InputFormat(ctx, r, opts)
+}
+
+func (Format) Validate(ctx context.Context, r io.Reader, opts *deanimator.Options) error {
+	return Validate(ctx, r, opts)
+}
+
 func init() {
-	deanimator.RegisterFormat("png", pngHeader, IsAnimated, RenderFirstFrame)
+	deanimator.Register(Format{})
 }
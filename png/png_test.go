@@ -2,14 +2,24 @@ package png
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
 	gopng "image/png"
 	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/slackhq/deanimator"
 	"github.com/slackhq/deanimator/goldentest"
+	"github.com/slackhq/deanimator/internal/fuzztest"
 )
 
 var (
@@ -68,7 +78,7 @@ func TestIsAnimated(t *testing.T) {
 			} else if err != nil {
 				if !expectUnderflowError {
 					t.Errorf("%d: expected no error, got %v", idx, err)
-				} else if err != errUnderflow && err != io.EOF {
+				} else if !errors.Is(err, deanimator.ErrTruncated) {
 					t.Errorf("%d: expected underflow error, got %v", idx, err)
 				}
 			}
@@ -164,3 +174,525 @@ func TestRenderFirstFrameMinimumDownload(t *testing.T) {
 		}
 	}
 }
+
+func TestContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := IsAnimatedContext(ctx, bytes.NewReader(animatedPNG), nil); err != context.Canceled {
+		t.Errorf("expected IsAnimatedContext to return context.Canceled, got %v", err)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	if _, err := RenderFirstFrameContext(ctx, bytes.NewReader(animatedPNG), w, nil); err != context.Canceled {
+		t.Errorf("expected RenderFirstFrameContext to return context.Canceled, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	defer resetPNGs()
+
+	for _, data := range [][]byte{animatedPNG, regularPNG} {
+		if err := Validate(context.Background(), bytes.NewReader(data), nil); err != nil {
+			t.Errorf("expected a valid png, got %v", err)
+		}
+	}
+
+	if err := Validate(context.Background(), bytes.NewReader(animatedPNG[:4736]), nil); !errors.Is(err, deanimator.ErrTruncated) {
+		t.Errorf("expected truncated error, got %v", err)
+	}
+
+	// flip a bit of the first IDAT payload
+	animatedPNG[4600] ^= 1
+	err := Validate(context.Background(), bytes.NewReader(animatedPNG), nil)
+	if !errors.Is(err, deanimator.ErrMalformed) {
+		t.Errorf("expected malformed error, got %v", err)
+	}
+	var perr *deanimator.ParseError
+	if !errors.As(err, &perr) || perr.Format != "png" || perr.Chunk != "IDAT" || perr.Offset != 91 {
+		t.Errorf("expected a png parse error for the IDAT chunk at offset 91, got %v", err)
+	}
+}
+
+func TestRenderFirstFrameNotAnimated(t *testing.T) {
+	w := bytes.NewBuffer([]byte{})
+	if err := RenderFirstFrame(bytes.NewReader(regularPNG), w); err != deanimator.ErrNotAnimated {
+		t.Errorf("expected ErrNotAnimated, got %v", err)
+	}
+	if w.Len() != 0 {
+		t.Errorf("expected nothing to be written for a still image, got %d bytes", w.Len())
+	}
+}
+
+func TestRenderFirstFrameSingleFrame(t *testing.T) {
+	// an APNG whose only frame is its default image
+	actlData := make([]byte, 8)
+	binary.BigEndian.PutUint32(actlData, 1)
+	ihdrEnd := len(pngHeader) + 25
+	data := bytes.Join([][]byte{
+		regularPNG[:ihdrEnd],
+		chunk(actl, actlData),
+		chunk(fctl, make([]byte, 26)),
+		regularPNG[ihdrEnd:],
+	}, nil)
+
+	w := bytes.NewBuffer([]byte{})
+	res, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.InputFormat != "apng" || res.Frames != 1 {
+		t.Errorf("expected a single frame apng, got %+v", *res)
+	}
+	if _, err := gopng.Decode(w); err != nil {
+		t.Errorf("expected a valid png, got %v", err)
+	}
+}
+
+func TestRenderFirstFrameResult(t *testing.T) {
+	w := bytes.NewBuffer([]byte{})
+	res, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(animatedPNG), w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := deanimator.Result{
+		InputFormat:  "apng",
+		OutputFormat: "png",
+		MIMEType:     "image/png",
+		Width:        100,
+		Height:       100,
+		Frames:       20,
+	}
+	if *res != expected {
+		t.Errorf("expected result %+v, got %+v", expected, *res)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, tc := range []struct {
+		limits      deanimator.Limits
+		expectLimit string
+	}{
+		{deanimator.Limits{MaxPixels: 100 * 100, MaxBytes: int64(len(animatedPNG)), MaxChunkSize: 8192}, ""},
+		{deanimator.Limits{MaxPixels: 100*100 - 1}, "MaxPixels"},
+		{deanimator.Limits{MaxBytes: 1000}, "MaxBytes"},
+		{deanimator.Limits{MaxChunkSize: 100}, "MaxChunkSize"},
+	} {
+		t.Run(fmt.Sprintf("%+v", tc.limits), func(t *testing.T) {
+			w := bytes.NewBuffer([]byte{})
+			_, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(animatedPNG), w, &deanimator.Options{Limits: &tc.limits})
+			checkLimitError(t, err, tc.expectLimit)
+		})
+	}
+
+	limits := &deanimator.Limits{MaxFrames: 19}
+	err := Validate(context.Background(), bytes.NewReader(animatedPNG), &deanimator.Options{Limits: limits})
+	checkLimitError(t, err, "MaxFrames")
+}
+
+func checkLimitError(t *testing.T, err error, expectLimit string) {
+	t.Helper()
+	if expectLimit == "" {
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		return
+	}
+	var lerr *deanimator.LimitError
+	if !errors.Is(err, deanimator.ErrLimitExceeded) || !errors.As(err, &lerr) || lerr.Limit != expectLimit {
+		t.Errorf("expected %s to be exceeded, got %v", expectLimit, err)
+	}
+}
+
+// FuzzFormat checks that no input makes any capability of the png format panic, and that its
+// incremental detector agrees with VerifyAnimated.
+func FuzzFormat(f *testing.F) {
+	fuzztest.Fuzz(f, Format{}, animatedPNG, regularPNG)
+}
+
+func TestFirstFrame(t *testing.T) {
+	golden, err := ioutil.ReadFile("../testdata/animated_golden.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := gopng.Decode(bytes.NewReader(golden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := FirstFrame(bytes.NewReader(animatedPNG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected the first frame to match the rendered golden file")
+	}
+
+	m, err = FirstFrame(bytes.NewReader(regularPNG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ = gopng.Decode(bytes.NewReader(regularPNG))
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected a still png to be its own first frame")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	info, err := Inspect(context.Background(), bytes.NewReader(animatedPNG), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 100 || info.Height != 100 || info.Frames != 20 || info.LoopCount != 0 || !info.HasAlpha {
+		t.Errorf("expected 20 frames of 100x100 looping forever with alpha, got %+v", *info)
+	}
+	if len(info.Delays) != 20 || info.Delays[0] != 75*time.Millisecond || info.Duration != 1500*time.Millisecond {
+		t.Errorf("expected 20 delays of 75ms lasting 1.5s, got %v lasting %v", info.Delays, info.Duration)
+	}
+
+	info, err = Inspect(context.Background(), bytes.NewReader(regularPNG), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := deanimator.Info{Format: "png", Width: 128, Height: 128, Frames: 1, LoopCount: 1, HasAlpha: true}
+	if !reflect.DeepEqual(*info, expected) {
+		t.Errorf("expected info %+v, got %+v", expected, *info)
+	}
+}
+
+// chunk returns a PNG chunk of the given type and data.
+func chunk(chunkType string, data []byte) []byte {
+	b := make([]byte, 12+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], chunkType)
+	copy(b[8:], data)
+	binary.BigEndian.PutUint32(b[8+len(data):], crc32.ChecksumIEEE(b[4:8+len(data)]))
+	return b
+}
+
+func TestVerifyAnimated(t *testing.T) {
+	actlData := func(frames uint32) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint32(b, frames)
+		return b
+	}
+	ihdrData := []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 6, 0, 0, 0}
+	fctlData := make([]byte, 26)
+	build := func(chunks ...[]byte) []byte {
+		return bytes.Join(append([][]byte{[]byte(pngHeader), chunk(ihdr, ihdrData)}, chunks...), nil)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		data         []byte
+		expect       deanimator.State
+		expectReason string
+	}{
+		{"animated", animatedPNG, deanimator.Animated, "acTL declares 20 frames and a second fcTL follows"},
+		{"still", regularPNG, deanimator.Static, "IDAT precedes any acTL"},
+		{"one frame", build(chunk(actl, actlData(1)), chunk(fctl, fctlData), chunk(idat, nil), chunk(iend, nil)), deanimator.Static, "acTL declares only 1 frame(s)"},
+		{"missing frames", build(chunk(actl, actlData(2)), chunk(fctl, fctlData), chunk(idat, nil), chunk(iend, nil)), deanimator.Static, "acTL declares 2 frames but only 1 fcTL precedes IEND"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := VerifyAnimated(context.Background(), bytes.NewReader(tc.data), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.State != tc.expect || d.Reason != tc.expectReason {
+				t.Errorf("expected %v because %q, got %v because %q", tc.expect, tc.expectReason, d.State, d.Reason)
+			}
+
+			// the incremental detector must agree when the data arrives a byte at a time
+			inc := NewIncrementalDetector(nil)
+			var pushed *deanimator.Detection
+			for i := 0; i < len(tc.data) && pushed == nil; i++ {
+				if pushed, err = inc.Write(tc.data[i : i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if pushed == nil || pushed.State != d.State || pushed.Reason != d.Reason {
+				t.Errorf("expected the incremental detector to agree with %+v, got %+v", *d, pushed)
+			}
+		})
+	}
+}
+
+func TestRenderFrame(t *testing.T) {
+	transparent := color.NRGBA{}
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	green := color.NRGBA{G: 0xff, A: 0xff}
+	white := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	palette := color.Palette{transparent, red, blue, green, white}
+
+	// encode returns the IHDR, PLTE and tRNS chunks and the image data of a width x 1 image of c
+	encode := func(width int, c color.Color) ([]byte, []byte) {
+		m := image.NewPaletted(image.Rect(0, 0, width, 1), palette)
+		for x := 0; x < width; x++ {
+			m.Set(x, 0, c)
+		}
+		w := bytes.NewBuffer([]byte{})
+		if err := gopng.Encode(w, m); err != nil {
+			t.Fatal(err)
+		}
+		var chunks, data []byte
+		for b := w.Bytes()[len(pngHeader):]; len(b) > 0; {
+			length := int(binary.BigEndian.Uint32(b))
+			switch string(b[4:8]) {
+			case ihdr, plte, trns:
+				chunks = append(chunks, b[:12+length]...)
+			case idat:
+				data = append(data, b[8:8+length]...)
+			}
+			b = b[12+length:]
+		}
+		return chunks, data
+	}
+	fctlData := func(seq, width, x int, dispose, blend byte) []byte {
+		b := make([]byte, 26)
+		binary.BigEndian.PutUint32(b[0:4], uint32(seq))
+		binary.BigEndian.PutUint32(b[4:8], uint32(width))
+		binary.BigEndian.PutUint32(b[8:12], 1)
+		binary.BigEndian.PutUint32(b[12:16], uint32(x))
+		binary.BigEndian.PutUint16(b[20:22], 1)
+		b[24], b[25] = dispose, blend
+		return b
+	}
+	fdatData := func(seq int, data []byte) []byte {
+		b := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(b, uint32(seq))
+		return append(b, data...)
+	}
+	actlData := make([]byte, 8)
+	binary.BigEndian.PutUint32(actlData, 4)
+
+	chunks, redData := encode(4, red)
+	_, blueData := encode(2, blue)
+	_, greenData := encode(1, green)
+	_, whiteData := encode(1, white)
+	data := bytes.Join([][]byte{
+		[]byte(pngHeader),
+		chunks[:25], // IHDR
+		chunk(actl, actlData),
+		chunks[25:], // PLTE and tRNS
+		chunk(fctl, fctlData(0, 4, 0, 0, 0)),
+		chunk(idat, redData),
+		chunk(fctl, fctlData(1, 2, 0, 2, 1)), // restores red
+		chunk(fdat, fdatData(2, blueData)),
+		chunk(fctl, fctlData(3, 1, 2, 1, 1)), // clears to transparent
+		chunk(fdat, fdatData(4, greenData)),
+		chunk(fctl, fctlData(5, 1, 3, 0, 1)),
+		chunk(fdat, fdatData(6, whiteData)),
+		chunk(iend, nil),
+	}, nil)
+
+	for index, expect := range [][]color.NRGBA{
+		{red, red, red, red},
+		{blue, blue, red, red},
+		{red, red, green, red},
+		{red, red, transparent, white},
+	} {
+		w := bytes.NewBuffer([]byte{})
+		if err := RenderFrame(bytes.NewReader(data), w, index); err != nil {
+			t.Fatal(err)
+		}
+		m, err := gopng.Decode(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x, c := range expect {
+			if got := color.NRGBAModel.Convert(m.At(x, 0)); got != c {
+				t.Errorf("frame %d: expected %v at %d, got %v", index, c, x, got)
+			}
+		}
+	}
+
+	if err := RenderFrame(bytes.NewReader(data), io.Discard, 4); !errors.Is(err, deanimator.ErrFrameIndex) {
+		t.Errorf("expected ErrFrameIndex past the last frame, got %v", err)
+	}
+
+	// without an "fcTL" chunk before it, the default image is not the first frame
+	hidden := bytes.Join([][]byte{
+		[]byte(pngHeader),
+		chunks[:25], // IHDR
+		chunk(actl, actlData),
+		chunks[25:], // PLTE and tRNS
+		chunk(idat, redData),
+		chunk(fctl, fctlData(0, 2, 0, 0, 1)),
+		chunk(fdat, fdatData(1, blueData)),
+		chunk(iend, nil),
+	}, nil)
+	w := bytes.NewBuffer([]byte{})
+	if err := RenderFirstFrame(bytes.NewReader(hidden), w); err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := gopng.Decode(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := FirstFrame(bytes.NewReader(hidden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, c := range []color.NRGBA{blue, blue, transparent, transparent} {
+		if got := color.NRGBAModel.Convert(rendered.At(x, 0)); got != c {
+			t.Errorf("expected %v at %d of the rendered first frame, got %v", c, x, got)
+		}
+		if got := color.NRGBAModel.Convert(first.At(x, 0)); got != c {
+			t.Errorf("expected %v at %d of the first frame, got %v", c, x, got)
+		}
+	}
+
+	// a still image is its only frame
+	w.Reset()
+	if err := RenderFrame(bytes.NewReader(regularPNG), w, 0); err != nil {
+		t.Fatal(err)
+	}
+	still, err := gopng.Decode(bytes.NewReader(regularPNG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := gopng.Decode(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds() != still.Bounds() || color.NRGBAModel.Convert(m.At(64, 64)) != color.NRGBAModel.Convert(still.At(64, 64)) {
+		t.Errorf("expected the still image")
+	}
+	if err := RenderFrame(bytes.NewReader(regularPNG), io.Discard, 1); !errors.Is(err, deanimator.ErrFrameIndex) {
+		t.Errorf("expected ErrFrameIndex past the still image, got %v", err)
+	}
+}
+
+// withPrivateChunk returns data with a private chunk of size bytes inserted after its IHDR chunk.
+func withPrivateChunk(data []byte, size int) []byte {
+	const afterIHDR = len(pngHeader) + 12 + 13
+	b := append([]byte{}, data[:afterIHDR]...)
+	b = append(b, chunk("prVt", make([]byte, size))...)
+	return append(b, data[afterIHDR:]...)
+}
+
+// seekingReader counts the bytes read from a bytes.Reader.
+type seekingReader struct {
+	*bytes.Reader
+	read int64
+}
+
+func (r *seekingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func TestSeek(t *testing.T) {
+	data := withPrivateChunk(animatedPNG, 1<<20)
+
+	r := &seekingReader{Reader: bytes.NewReader(data)}
+	animated, err := IsAnimated(r)
+	if err != nil || !animated {
+		t.Fatalf("expected IsAnimated == true, got %v, %v", animated, err)
+	}
+	if r.read > 4096 {
+		t.Errorf("expected the private chunk to be seeked over, read %d bytes", r.read)
+	}
+
+	var seeked, read bytes.Buffer
+	r = &seekingReader{Reader: bytes.NewReader(data)}
+	if err := RenderFirstFrame(r, &seeked); err != nil {
+		t.Fatal(err)
+	}
+	if r.read > int64(len(animatedPNG)) {
+		t.Errorf("expected the private chunk to be seeked over, read %d bytes", r.read)
+	}
+	if err := RenderFirstFrame(struct{ io.Reader }{bytes.NewReader(data)}, &read); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(seeked.Bytes(), read.Bytes()) {
+		t.Errorf("expected the same output when seeking")
+	}
+
+	// truncated within the private chunk, which is needed in full
+	need := int64(33 + 12 + 1<<20 - 1000)
+	for _, r := range []io.Reader{bytes.NewReader(data[:1000]), struct{ io.Reader }{bytes.NewReader(data[:1000])}} {
+		_, err := IsAnimated(r)
+		var nerr *deanimator.NeedMoreDataError
+		if !errors.As(err, &nerr) || nerr.Need != need {
+			t.Errorf("%T: expected to need %d more bytes, got %v", r, need, err)
+		}
+	}
+
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxBytes: 1 << 19}}
+	_, err = IsAnimatedContext(context.Background(), bytes.NewReader(data), opts)
+	checkLimitError(t, err, "MaxBytes")
+}
+
+func BenchmarkIsAnimated(b *testing.B) {
+	data := withPrivateChunk(animatedPNG, 16<<20)
+	for _, bc := range []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{"Seeker", func() io.Reader { return bytes.NewReader(data) }},
+		{"Reader", func() io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := IsAnimated(bc.reader()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRenderFirstFrame(b *testing.B) {
+	data := withPrivateChunk(animatedPNG, 16<<20)
+	for _, bc := range []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{"Seeker", func() io.Reader { return bytes.NewReader(data) }},
+		{"Reader", func() io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := RenderFirstFrame(bc.reader(), io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkFrames(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/animated.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := 0
+	err = WalkFrames(bytes.NewReader(data), func(m image.Image) error {
+		frames++
+		return nil
+	})
+	if err != nil || frames != 20 {
+		t.Errorf("expected 20 frames, got %d, %v", frames, err)
+	}
+
+	// an error returned by fn stops the walk
+	stop := errors.New("stop")
+	frames = 0
+	err = WalkFrames(bytes.NewReader(data), func(m image.Image) error {
+		frames++
+		return stop
+	})
+	if err != stop || frames != 1 {
+		t.Errorf("expected the walk to stop after 1 frame, got %d, %v", frames, err)
+	}
+
+	// a size past the 31 bits the spec allows is malformed rather than allocated
+	huge := append([]byte{}, regularPNG...)
+	copy(huge[len(pngHeader)+8:], []byte{0xf8, 0, 0, 0, 0x8d, 0, 0, 0})
+	err = WalkFrames(bytes.NewReader(huge), func(m image.Image) error { return nil })
+	if !errors.Is(err, deanimator.ErrMalformed) {
+		t.Errorf("expected ErrMalformed, got %v", err)
+	}
+}
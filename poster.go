@@ -0,0 +1,278 @@
+package deanimator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// A PosterStrategy picks the frame of an animation that stands in for it as a still image.
+type PosterStrategy int
+
+const (
+	// PosterFirst picks the first frame, it is the default.
+	PosterFirst PosterStrategy = iota
+
+	// PosterLast picks the last frame, which animations that build up their image end on.
+	PosterLast
+
+	// PosterFirstNonEmpty picks the first frame that is not fully transparent or a single color,
+	// skipping the blank frames animations often start or fade in with. If every frame is empty it
+	// picks the first frame.
+	PosterFirstNonEmpty
+
+	// PosterMostRepresentative picks the frame closest to the mean of all frames, comparing them
+	// downsampled to a small grid. It reads the image twice, so it keeps what it read in memory,
+	// failing once that is more than the MaxBytes limit.
+	PosterMostRepresentative
+)
+
+func (s PosterStrategy) String() string {
+	switch s {
+	case PosterFirst:
+		return "first"
+	case PosterLast:
+		return "last"
+	case PosterFirstNonEmpty:
+		return "first non-empty"
+	case PosterMostRepresentative:
+		return "most representative"
+	}
+	return fmt.Sprintf("PosterStrategy(%d)", int(s))
+}
+
+// poster returns the poster strategy of the options.
+func (o *Options) poster() PosterStrategy {
+	if o == nil {
+		return PosterFirst
+	}
+	return o.Poster
+}
+
+// errStopWalk stops walking the frames once the poster is found.
+var errStopWalk = errors.New("deanimator: stop walking frames")
+
+// renderPoster writes the poster of the image in r picked by the strategy of opts to w as a PNG. A
+// still image is its own poster, it is rendered as PosterFirst renders it.
+func renderPoster(ctx context.Context, f Format, r io.Reader, w io.Writer, opts *Options) (*Result, error) {
+	// keep what is read, so the frames can be walked from the start
+	r = opts.LimitReader(r)
+	read := bytes.NewBuffer([]byte{})
+	animated, err := isAnimated(ctx, f, io.TeeReader(r, read), opts)
+	if err != nil {
+		return nil, err
+	}
+	r = io.MultiReader(read, r)
+	if !animated {
+		var res *Result
+		err = guard(opts, f, func() error {
+			res, err = f.RenderFirstFrame(ctx, r, w, opts)
+			return err
+		})
+		return res, err
+	}
+
+	inputFormat := f.Name()
+	if fi, ok := f.(InputFormatter); ok {
+		read := bytes.NewBuffer([]byte{})
+		err := guard(opts, f, func() (err error) {
+			inputFormat, err = fi.InputFormat(ctx, io.TeeReader(r, read), opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		r = io.MultiReader(read, r)
+	}
+
+	m, frames, err := posterFrame(ctx, f, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := png.Encode(w, m); err != nil {
+		return nil, err
+	}
+	return &Result{
+		InputFormat:  inputFormat,
+		OutputFormat: "png",
+		MIMEType:     "image/png",
+		Width:        m.Bounds().Dx(),
+		Height:       m.Bounds().Dy(),
+		Frames:       frames,
+	}, nil
+}
+
+// posterFrame returns the poster of the image in r picked by the strategy of opts, and the number
+// of frames of the image if it walked all of them, otherwise 0. It is guarded according to opts.
+func posterFrame(ctx context.Context, f Format, r io.Reader, opts *Options) (poster *image.RGBA, frames int, err error) {
+	fw, ok := f.(FrameWalker)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s can't pick a %s poster frame: %w", f.Name(), opts.poster(), ErrUnsupported)
+	}
+	err = guard(opts, f, func() error {
+		switch s := opts.poster(); s {
+		case PosterFirst, PosterLast, PosterFirstNonEmpty:
+			poster, frames, err = walkPoster(ctx, fw, r, opts, s)
+		case PosterMostRepresentative:
+			poster, frames, err = representativePoster(ctx, fw, r, opts)
+		default:
+			err = fmt.Errorf("%v: %w", s, ErrUnsupported)
+		}
+		return err
+	})
+	return poster, frames, err
+}
+
+// walkPoster walks the frames until it finds the poster picked by s, which is not
+// PosterMostRepresentative.
+func walkPoster(ctx context.Context, fw FrameWalker, r io.Reader, opts *Options, s PosterStrategy) (*image.RGBA, int, error) {
+	var poster *image.RGBA
+	frames := 0
+	err := fw.WalkFrames(ctx, r, opts, func(m image.Image) error {
+		frames++
+		switch {
+		case poster == nil:
+			poster = clone(nil, m)
+			if s == PosterFirst || (s == PosterFirstNonEmpty && !isEmpty(poster)) {
+				return errStopWalk
+			}
+		case s == PosterLast:
+			poster = clone(poster, m)
+		case s == PosterFirstNonEmpty && !isEmpty(toRGBA(m)):
+			poster = clone(poster, m)
+			return errStopWalk
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		// the frames after the poster were not walked, so their number is not known
+		return poster, 0, nil
+	} else if err == nil && poster != nil {
+		return poster, frames, nil
+	} else if err == nil {
+		err = fmt.Errorf("no frames: %w", ErrMalformed)
+	}
+	return nil, frames, err
+}
+
+// representativePoster walks the frames once to find the frame closest to their mean, and again to
+// return it.
+func representativePoster(ctx context.Context, fw FrameWalker, r io.Reader, opts *Options) (*image.RGBA, int, error) {
+	// keep what is read, so the frames can be walked again
+	r = opts.LimitReader(r)
+	read := bytes.NewBuffer([]byte{})
+	var grids [][]float64
+	err := fw.WalkFrames(ctx, io.TeeReader(r, read), opts, func(m image.Image) error {
+		grids = append(grids, sampleGrid(toRGBA(m)))
+		return nil
+	})
+	if err != nil {
+		return nil, len(grids), err
+	}
+	if len(grids) == 0 {
+		return nil, 0, fmt.Errorf("no frames: %w", ErrMalformed)
+	}
+
+	mean := make([]float64, len(grids[0]))
+	for _, g := range grids {
+		for i, v := range g {
+			mean[i] += v / float64(len(grids))
+		}
+	}
+	index, closest := 0, -1.0
+	for i, g := range grids {
+		d := 0.0
+		for j, v := range g {
+			d += (v - mean[j]) * (v - mean[j])
+		}
+		if closest < 0 || d < closest {
+			index, closest = i, d
+		}
+	}
+
+	var poster *image.RGBA
+	frame := 0
+	err = fw.WalkFrames(ctx, io.MultiReader(read, r), opts, func(m image.Image) error {
+		if frame == index {
+			poster = clone(nil, m)
+			return errStopWalk
+		}
+		frame++
+		return nil
+	})
+	if err != errStopWalk {
+		if err == nil {
+			err = fmt.Errorf("frame %d missing from the second walk: %w", index, ErrMalformed)
+		}
+		return nil, len(grids), err
+	}
+	return poster, len(grids), nil
+}
+
+// clone copies m into dst, allocating dst if it is nil or of other bounds.
+func clone(dst *image.RGBA, m image.Image) *image.RGBA {
+	if dst == nil || dst.Rect != m.Bounds() {
+		dst = image.NewRGBA(m.Bounds())
+	}
+	draw.Draw(dst, dst.Rect, m, dst.Rect.Min, draw.Src)
+	return dst
+}
+
+// toRGBA returns m as an *image.RGBA, converting it if it is not one already.
+func toRGBA(m image.Image) *image.RGBA {
+	if rgba, ok := m.(*image.RGBA); ok {
+		return rgba
+	}
+	return clone(nil, m)
+}
+
+// isEmpty reports whether every pixel of m has the same color, which includes fully transparent.
+func isEmpty(m *image.RGBA) bool {
+	if m.Rect.Empty() {
+		return true
+	}
+	first := m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y)
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(m.Rect.Min.X, y):m.PixOffset(m.Rect.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			if !bytes.Equal(row[i:i+4], m.Pix[first:first+4]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// gridSize is the number of cells across and down that frames are downsampled to for comparison.
+const gridSize = 8
+
+// sampleGrid downsamples m to gridSize by gridSize cells, and returns the mean of the red, green,
+// blue and alpha values of each cell.
+func sampleGrid(m *image.RGBA) []float64 {
+	grid := make([]float64, 4*gridSize*gridSize)
+	counts := make([]float64, gridSize*gridSize)
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := m.Pix[m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			cell := y*gridSize/h*gridSize + x*gridSize/w
+			for c := 0; c < 4; c++ {
+				grid[4*cell+c] += float64(row[4*x+c])
+			}
+			counts[cell]++
+		}
+	}
+	for cell, n := range counts {
+		if n > 0 {
+			for c := 0; c < 4; c++ {
+				grid[4*cell+c] /= n
+			}
+		}
+	}
+	return grid
+}
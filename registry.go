@@ -0,0 +1,564 @@
+package deanimator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Registry holds a set of formats and dispatches detection and rendering to them. Registries are
+// independent of each other, so components of the same program can support different formats. The
+// zero value is an empty registry ready to use.
+type Registry struct {
+	mu      sync.Mutex
+	formats atomic.Value // []Format
+}
+
+// DefaultRegistry is the registry used by the package level functions. The gif, png and webp
+// packages register themselves to it when imported.
+var DefaultRegistry = &Registry{}
+
+// Register adds a format to the registry. Registering a name again replaces the earlier format in
+// place.
+func (r *Registry) Register(f Format) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.load()
+	formats := make([]Format, 0, len(old)+1)
+	replaced := false
+	for _, o := range old {
+		if o.Name() == f.Name() {
+			o, replaced = f, true
+		}
+		formats = append(formats, o)
+	}
+	if !replaced {
+		formats = append(formats, f)
+	}
+	r.formats.Store(formats)
+}
+
+// Unregister removes the named format from the registry and reports whether it was registered.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.load()
+	formats := make([]Format, 0, len(old))
+	for _, o := range old {
+		if o.Name() != name {
+			formats = append(formats, o)
+		}
+	}
+	r.formats.Store(formats)
+	return len(formats) != len(old)
+}
+
+// Formats returns the names of the registered formats in the order they are tried.
+func (r *Registry) Formats() []string {
+	formats := r.load()
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name()
+	}
+	return names
+}
+
+// Lookup returns the named format. Callers can check the returned format for capability interfaces
+// such as Inspector before dispatching work to it.
+func (r *Registry) Lookup(name string) (Format, bool) {
+	for _, f := range r.load() {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) load() []Format {
+	formats, _ := r.formats.Load().([]Format)
+	return formats
+}
+
+// sniff determines the format of rr's data among the formats allowed by opts, it returns
+// ErrFormat if none match, or a *NeedMoreDataError if the data ends within the magic of one. When
+// several formats match, the most specific one wins: the one whose magic has the most non-wildcard
+// bytes, or whose Sniffer reported the most identifying bytes. Ties go to the format registered
+// first.
+func (r *Registry) sniff(rr reader, opts *Options) (Format, error) {
+	var best Format
+	bestScore := 0
+	need := 0
+	for _, f := range r.load() {
+		if !opts.allows(f.Name()) {
+			continue
+		}
+		score := 0
+		if s, ok := f.(Sniffer); ok {
+			err := guard(opts, f, func() error {
+				score = s.Sniff(rr.Peek)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		} else if b, err := rr.Peek(len(f.Magic())); err == nil && match(f.Magic(), b) {
+			score = specificity(f.Magic())
+		} else if err == io.EOF && len(b) > 0 && match(f.Magic()[:len(b)], b) {
+			// the input may still turn out to be of this format once more of it arrives
+			if n := len(f.Magic()) - len(b); need == 0 || n < need {
+				need = n
+			}
+		}
+		if score > bestScore {
+			best, bestScore = f, score
+		}
+	}
+	if best == nil {
+		if need > 0 {
+			return nil, NeedMoreData(int64(need))
+		}
+		return nil, ErrFormat
+	}
+	return best, nil
+}
+
+// guard calls fn, and if opts.RecoverPanics is set, returns a panic in it as an *InternalError
+// for f.
+func guard(opts *Options, f Format, fn func() error) (err error) {
+	if opts == nil || !opts.RecoverPanics {
+		return fn()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = &InternalError{Format: f.Name(), Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// isAnimated calls f.IsAnimated, guarded according to opts.
+func isAnimated(ctx context.Context, f Format, r io.Reader, opts *Options) (animated bool, err error) {
+	err = guard(opts, f, func() error {
+		animated, err = f.IsAnimated(ctx, r, opts)
+		return err
+	})
+	return animated, err
+}
+
+// renderFirstFrame calls f.RenderFirstFrame, guarded according to opts. If opts picks another
+// poster frame it renders that instead.
+func renderFirstFrame(ctx context.Context, f Format, r io.Reader, w io.Writer, opts *Options) (res *Result, err error) {
+	if opts.poster() != PosterFirst {
+		return renderPoster(ctx, f, r, w, opts)
+	}
+	err = guard(opts, f, func() error {
+		res, err = f.RenderFirstFrame(ctx, r, w, opts)
+		return err
+	})
+	return res, err
+}
+
+// specificity returns the number of non-wildcard bytes of magic.
+func specificity(magic string) int {
+	return len(magic) - strings.Count(magic, "?")
+}
+
+// Sniff is like the package level Sniff, but only considers the formats of the registry that opts
+// allows.
+func (r *Registry) Sniff(rd io.Reader, opts *Options) (string, error) {
+	rr, err := asPeeker(rd)
+	if err != nil {
+		return "", err
+	}
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// IsAnimated is like the package level IsAnimatedContext, but only considers the formats of the
+// registry.
+func (r *Registry) IsAnimated(ctx context.Context, rd io.Reader, opts *Options) (bool, string, error) {
+	if err := ctx.Err(); err != nil {
+		return false, "", err
+	}
+	rr := asReader(rd)
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return false, "", err
+	}
+	b, err := isAnimated(ctx, f, rr, opts)
+	return b, f.Name(), err
+}
+
+// RenderFirstFrame is like the package level RenderFirstFrameContext, but only considers the
+// formats of the registry.
+func (r *Registry) RenderFirstFrame(ctx context.Context, rd io.Reader, w io.Writer, opts *Options) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cr := &countingReader{r: rd}
+	rr := asReader(cr.seekable())
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := renderFirstFrame(ctx, f, rr, w, opts)
+	return complete(res, f, cr), err
+}
+
+// complete fills in the fields of a result returned by f that the registry knows about.
+func complete(res *Result, f Format, cr *countingReader) *Result {
+	if res == nil {
+		res = &Result{}
+	}
+	res.Format = f.Name()
+	if res.InputFormat == "" {
+		res.InputFormat = res.Format
+	}
+	res.BytesRead = cr.n
+	return res
+}
+
+// Detect is like the package level DetectContext, but only considers the formats of the registry.
+// Formats that do not implement AnimationVerifier fall back to their unverified IsAnimated.
+func (r *Registry) Detect(ctx context.Context, rd io.Reader, opts *Options) (*Detection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rr reader
+	if _, ok := rd.(reader); !ok && opts != nil && opts.DetectionBudget > 0 {
+		// buffering ahead would take more than the budget from the caller's stream
+		rr = &exactReader{r: rd}
+	} else {
+		rr = asReader(rd)
+	}
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var br *budgetReader
+	if opts != nil && opts.DetectionBudget > 0 {
+		br = &budgetReader{r: rr, remaining: opts.DetectionBudget}
+		if v, ok := f.(IncrementalVerifier); ok {
+			br.inc, _ = v.NewIncrementalDetector(opts).(SkippingDetector)
+		}
+		rd = br
+	} else {
+		rd = rr
+	}
+
+	var d *Detection
+	if v, ok := f.(AnimationVerifier); ok {
+		err = guard(opts, f, func() error {
+			d, err = v.VerifyAnimated(ctx, rd, opts)
+			return err
+		})
+	} else {
+		var animated bool
+		animated, err = isAnimated(ctx, f, rd, opts)
+		if err == nil {
+			d = &Detection{
+				State:  Static,
+				Reason: fmt.Sprintf("%s does not verify frames, reported animated == %v", f.Name(), animated),
+			}
+			if animated {
+				d.State = Animated
+			}
+		}
+	}
+	if err != nil && br != nil && br.need > 0 && ctx.Err() == nil {
+		// formats may wrap or replace read errors, so rely on the reader to tell it ran dry
+		d, err = &Detection{
+			State:     Undetermined,
+			NeedBytes: br.need,
+			Reason:    fmt.Sprintf("detection budget of %d bytes exhausted", opts.DetectionBudget),
+		}, nil
+	}
+	if d != nil {
+		d.Format = f.Name()
+	}
+	return d, err
+}
+
+// errBudget is returned by budgetReader once the DetectionBudget is spent.
+var errBudget = errors.New("deanimator: detection budget exhausted")
+
+// budgetReader reads up to remaining bytes from r, and records how many more the format needed once
+// they are spent. The bytes read are written to inc, the incremental detector of the format if it
+// has one, whose Skip and Need tell how far the next evidence is. Without it, need is the length of
+// the first read past the budget, which is only an estimate.
+type budgetReader struct {
+	r         io.Reader
+	remaining int64
+	need      int64
+	inc       SkippingDetector
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if b.remaining <= 0 {
+		if b.need == 0 {
+			b.need = int64(len(p))
+			if b.inc != nil {
+				b.need = b.inc.Skip() + int64(b.inc.Need())
+				if b.need < 1 {
+					b.need = 1
+				}
+			}
+		}
+		return 0, errBudget
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.inc != nil && n > 0 {
+		if d, werr := b.inc.Write(p[:n]); d != nil || werr != nil {
+			// the detector decided, so it cannot tell what more the format needs
+			b.inc = nil
+		}
+	}
+	return n, err
+}
+
+// ReadByte keeps readers that need an io.ByteReader, such as the GIF parser, from buffering past
+// the budget.
+func (b *budgetReader) ReadByte() (byte, error) {
+	var p [1]byte
+	if _, err := io.ReadFull(b, p[:]); err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// FirstFrame is like the package level FirstFrameContext, but only considers the formats of the
+// registry.
+func (r *Registry) FirstFrame(ctx context.Context, rd io.Reader, opts *Options) (image.Image, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	rr := asReader(rd)
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.poster() != PosterFirst {
+		m, _, err := posterFrame(ctx, f, rr, opts)
+		if err != nil {
+			return nil, f.Name(), err
+		}
+		return m, f.Name(), nil
+	}
+
+	var m image.Image
+	err = guard(opts, f, func() error {
+		if d, ok := f.(FirstFrameDecoder); ok {
+			m, err = d.FirstFrame(ctx, rr, opts)
+			return err
+		}
+
+		// keep what is read, so a still image can be decoded as it is
+		src := opts.LimitReader(rr)
+		read := bytes.NewBuffer([]byte{})
+		buf := bytes.NewBuffer([]byte{})
+		_, err := f.RenderFirstFrame(ctx, io.TeeReader(src, read), buf, opts)
+		if err == ErrNotAnimated {
+			buf = read
+			_, err = io.Copy(buf, ContextReader(ctx, src))
+		}
+		if err != nil {
+			return err
+		}
+		m, _, err = image.Decode(buf)
+		if err == image.ErrFormat {
+			return fmt.Errorf("no decoder for the %s output: %w", f.Name(), ErrUnsupported)
+		}
+		return err
+	})
+	return m, f.Name(), err
+}
+
+// Inspect is like the package level InspectContext, but only considers the formats of the
+// registry.
+func (r *Registry) Inspect(ctx context.Context, rd io.Reader, opts *Options) (*Info, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rr := asReader(rd)
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := f.(Inspector)
+	if !ok {
+		return nil, fmt.Errorf("%s can't be inspected: %w", f.Name(), ErrUnsupported)
+	}
+
+	var info *Info
+	err = guard(opts, f, func() error {
+		info, err = i.Inspect(ctx, rr, opts)
+		return err
+	})
+	if info != nil && info.Format == "" {
+		info.Format = f.Name()
+	}
+	return info, err
+}
+
+// RenderFrame is like the package level RenderFrameContext, but only considers the formats of the
+// registry.
+func (r *Registry) RenderFrame(ctx context.Context, rd io.Reader, w io.Writer, index int, opts *Options) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	rr := asReader(rd)
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return "", err
+	}
+	fr, ok := f.(FrameRenderer)
+	if !ok {
+		return f.Name(), fmt.Errorf("%s can't render frames: %w", f.Name(), ErrUnsupported)
+	}
+	err = guard(opts, f, func() error {
+		return fr.RenderFrame(ctx, rr, w, index, opts)
+	})
+	return f.Name(), err
+}
+
+// RenderFrameAt is like the package level RenderFrameAtContext, but only considers the formats of
+// the registry.
+func (r *Registry) RenderFrameAt(ctx context.Context, rd io.Reader, w io.Writer, t time.Duration, opts *Options) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	rr := asReader(rd)
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return "", err
+	}
+	fr, ok := f.(FrameRenderer)
+	if !ok {
+		return f.Name(), fmt.Errorf("%s can't render frames: %w", f.Name(), ErrUnsupported)
+	}
+	i, ok := f.(Inspector)
+	if !ok {
+		return f.Name(), fmt.Errorf("%s can't be inspected: %w", f.Name(), ErrUnsupported)
+	}
+	err = guard(opts, f, func() error {
+		// keep what is read to find the frame, so it can be read again to render it
+		read := bytes.NewBuffer([]byte{})
+		info, err := i.Inspect(ctx, io.TeeReader(rr, read), opts)
+		if err != nil {
+			return err
+		}
+		return fr.RenderFrame(ctx, io.MultiReader(read, rr), w, info.FrameAt(t), opts)
+	})
+	return f.Name(), err
+}
+
+// Deanimate is like the package level DeanimateContext, but only considers the formats of the
+// registry.
+func (r *Registry) Deanimate(ctx context.Context, rd io.Reader, w io.Writer, opts *Options) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cr := &countingReader{r: rd}
+	rr := asReader(cr)
+	f, err := r.sniff(rr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep what detection reads, so it can be replayed to the renderer or copied through
+	detected := bytes.NewBuffer([]byte{})
+	animated, err := isAnimated(ctx, f, io.TeeReader(rr, detected), opts)
+	if err != nil {
+		return complete(nil, f, cr), err
+	}
+	src := io.MultiReader(detected, rr)
+
+	if animated {
+		res, err := renderFirstFrame(ctx, f, src, w, opts)
+		res = complete(res, f, cr)
+		res.Animated = true
+		return res, err
+	}
+
+	res := &Result{OutputFormat: f.Name(), MIMEType: mimeType(f.Name())}
+	copied := io.TeeReader(opts.LimitReader(ContextReader(ctx, src)), w)
+	if i, ok := f.(Inspector); ok {
+		// describe the image while it is copied through, from the same bytes
+		var info *Info
+		err = guard(opts, f, func() error {
+			info, err = i.Inspect(ctx, copied, opts)
+			return err
+		})
+		var lerr *LimitError
+		switch {
+		case err == nil:
+			res.Width, res.Height, res.Frames = info.Width, info.Height, info.Frames
+		case errors.As(err, &lerr), errors.Is(err, ErrInternal), ctx.Err() != nil:
+			return complete(res, f, cr), err
+		}
+		// an image the format can't describe is still copied through as it is
+	}
+	_, err = io.Copy(io.Discard, copied)
+	return complete(res, f, cr), err
+}
+
+// mimeTypes are the MIME types of the built-in formats, which the MIME tables of the system may
+// not know.
+var mimeTypes = map[string]string{
+	"gif":  "image/gif",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// mimeType returns the MIME type of the named format.
+func mimeType(name string) string {
+	if t, ok := mimeTypes[name]; ok {
+		return t
+	}
+	return mime.TypeByExtension("." + name)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// seekable returns c, able to seek if r can. Bytes seeked over are not counted.
+func (c *countingReader) seekable() io.Reader {
+	if s, ok := c.r.(io.Seeker); ok {
+		return &countingSeeker{c, s}
+	}
+	return c
+}
+
+type countingSeeker struct {
+	*countingReader
+	io.Seeker
+}
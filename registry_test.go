@@ -0,0 +1,370 @@
+package deanimator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// registerFake registers a format that reports every image as animated and renders its name.
+func registerFake(r *Registry, name, magic string) {
+	r.Register(&funcFormat{name, magic,
+		func(context.Context, io.Reader, *Options) (bool, error) {
+			return true, nil
+		},
+		func(_ context.Context, _ io.Reader, w io.Writer, _ *Options) error {
+			_, err := io.WriteString(w, name)
+			return err
+		},
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	registerFake(r, "bbb", "B?B")
+
+	if formats := r.Formats(); !reflect.DeepEqual(formats, []string{"aaa", "bbb"}) {
+		t.Fatalf("expected formats [aaa bbb], got %v", formats)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	res, err := r.RenderFirstFrame(context.Background(), strings.NewReader("BxB data"), w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Format != "bbb" || w.String() != "bbb" {
+		t.Errorf("expected bbb to render, got %q writing %q", res.Format, w.String())
+	}
+	if res.InputFormat != "bbb" || res.BytesRead != 8 {
+		t.Errorf("expected input format bbb and 8 bytes read, got %+v", *res)
+	}
+
+	if _, _, err := r.IsAnimated(context.Background(), strings.NewReader("CCC data"), nil); err != ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+
+	if !r.Unregister("aaa") {
+		t.Errorf("expected aaa to be unregistered")
+	}
+	if r.Unregister("aaa") {
+		t.Errorf("expected aaa to be unregistered only once")
+	}
+	if _, _, err := r.IsAnimated(context.Background(), strings.NewReader("AAA data"), nil); err != ErrFormat {
+		t.Errorf("expected ErrFormat after unregistering, got %v", err)
+	}
+
+	// registries do not share formats
+	if _, _, err := DefaultRegistry.IsAnimated(context.Background(), strings.NewReader("BxB data"), nil); err != ErrFormat {
+		t.Errorf("expected ErrFormat from the default registry, got %v", err)
+	}
+}
+
+func TestRegistryReplace(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	registerFake(r, "bbb", "BBB")
+	registerFake(r, "aaa", "XXX")
+
+	if formats := r.Formats(); !reflect.DeepEqual(formats, []string{"aaa", "bbb"}) {
+		t.Fatalf("expected formats [aaa bbb], got %v", formats)
+	}
+	if _, _, err := r.IsAnimated(context.Background(), strings.NewReader("AAA data"), nil); err != ErrFormat {
+		t.Errorf("expected ErrFormat for the replaced magic, got %v", err)
+	}
+	if _, name, err := r.IsAnimated(context.Background(), strings.NewReader("XXX data"), nil); err != nil || name != "aaa" {
+		t.Errorf("expected aaa, got %q, %v", name, err)
+	}
+}
+
+func TestRegistryAllowList(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	registerFake(r, "bbb", "BBB")
+
+	opts := &Options{Formats: []string{"bbb"}}
+	if _, _, err := r.IsAnimated(context.Background(), strings.NewReader("AAA data"), opts); err != ErrFormat {
+		t.Errorf("expected ErrFormat for a format outside the allow list, got %v", err)
+	}
+	if _, name, err := r.IsAnimated(context.Background(), strings.NewReader("BBB data"), opts); err != nil || name != "bbb" {
+		t.Errorf("expected bbb, got %q, %v", name, err)
+	}
+}
+
+// textFormat is a format recognized by a Sniffer instead of a magic header.
+type textFormat struct {
+	funcFormat
+}
+
+func (textFormat) Sniff(peek func(int) ([]byte, error)) int {
+	b, err := peek(5)
+	if err == nil && strings.TrimLeft(string(b), " ") == "<tx" {
+		return len(b)
+	}
+	return 0
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	r.Register(&textFormat{funcFormat{name: "text", isAnimated: func(context.Context, io.Reader, *Options) (bool, error) {
+		return false, nil
+	}}})
+
+	if _, ok := r.Lookup("bbb"); ok {
+		t.Errorf("expected bbb to not be registered")
+	}
+	f, ok := r.Lookup("aaa")
+	if !ok {
+		t.Fatalf("expected aaa to be registered")
+	}
+	if _, ok := f.(Sniffer); ok {
+		t.Errorf("expected aaa to not be a Sniffer")
+	}
+	f, _ = r.Lookup("text")
+	if _, ok := f.(Sniffer); !ok {
+		t.Errorf("expected text to be a Sniffer")
+	}
+
+	if _, name, err := r.IsAnimated(context.Background(), strings.NewReader("  <tx>"), nil); err != nil || name != "text" {
+		t.Errorf("expected the sniffer to match text, got %q, %v", name, err)
+	}
+}
+
+// svgFormat sniffs SVG documents, which may start with a byte order mark and whitespace.
+type svgFormat struct {
+	funcFormat
+}
+
+func (svgFormat) Sniff(peek func(int) ([]byte, error)) int {
+	b, _ := peek(512)
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("<svg")) {
+		return len(b) - len(trimmed) + len("<svg")
+	}
+	return 0
+}
+
+func TestRegistrySniff(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "riff", "RIFF")
+	registerFake(r, "webp", "RIFF????WEBP")
+	r.Register(&svgFormat{funcFormat{name: "svg"}})
+
+	for _, tc := range []struct {
+		data, expected string
+	}{
+		{"RIFF\x00\x00\x00\x00WAVE", "riff"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"\xef\xbb\xbf  \n<svg xmlns=\"http://www.w3.org/2000/svg\">", "svg"},
+	} {
+		rd := strings.NewReader(tc.data)
+		name, err := r.Sniff(rd, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != tc.expected {
+			t.Errorf("expected %q to sniff as %s, got %s", tc.data, tc.expected, name)
+		}
+		if rd.Len() != len(tc.data) {
+			t.Errorf("expected sniffing %q to consume nothing, %d bytes were consumed", tc.data, len(tc.data)-rd.Len())
+		}
+	}
+
+	if _, err := r.Sniff(strings.NewReader("<html>"), nil); err != ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+	if _, err := r.Sniff(strings.NewReader("RIFF....WAVE"), &Options{Formats: []string{"webp"}}); err != ErrFormat {
+		t.Errorf("expected ErrFormat for a format outside the allow list, got %v", err)
+	}
+	var needErr *NeedMoreDataError
+	if _, err := r.Sniff(strings.NewReader("RIFF....WE"), &Options{Formats: []string{"webp"}}); !errors.As(err, &needErr) || needErr.Need != 2 {
+		t.Errorf("expected to need 2 more bytes to sniff the start of a webp, got %v", err)
+	}
+}
+
+func TestSniffReaders(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+
+	br := bufio.NewReader(struct{ io.Reader }{strings.NewReader("AAA data")})
+	if name, err := r.Sniff(br, nil); err != nil || name != "aaa" {
+		t.Errorf("expected aaa, got %q, %v", name, err)
+	}
+	if rest, _ := io.ReadAll(br); string(rest) != "AAA data" {
+		t.Errorf("expected the bufio.Reader to be unconsumed, got %q", rest)
+	}
+
+	if _, err := r.Sniff(struct{ io.Reader }{strings.NewReader("AAA data")}, nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a plain reader, got %v", err)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	r := &Registry{}
+	r.Register(&funcFormat{"boom", "BOOM",
+		func(context.Context, io.Reader, *Options) (bool, error) {
+			panic("boom")
+		},
+		func(context.Context, io.Reader, io.Writer, *Options) error {
+			var m map[string]int
+			m["boom"]++
+			return nil
+		},
+	})
+
+	opts := &Options{RecoverPanics: true}
+	_, _, err := r.IsAnimated(context.Background(), strings.NewReader("BOOM"), opts)
+	var ierr *InternalError
+	if !errors.Is(err, ErrInternal) || !errors.As(err, &ierr) || ierr.Format != "boom" || ierr.Value != "boom" {
+		t.Errorf("expected an internal error from the boom format, got %v", err)
+	} else if !strings.Contains(string(ierr.Stack), "TestRecoverPanics") {
+		t.Errorf("expected the stack of the panic, got %s", ierr.Stack)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	if _, err := r.RenderFirstFrame(context.Background(), strings.NewReader("BOOM"), w, opts); !errors.Is(err, ErrInternal) {
+		t.Errorf("expected an internal error rendering, got %v", err)
+	}
+	if _, err := r.Deanimate(context.Background(), strings.NewReader("BOOM"), w, opts); !errors.Is(err, ErrInternal) {
+		t.Errorf("expected an internal error deanimating, got %v", err)
+	}
+
+	r.Register(panicSniffer{})
+	if _, err := r.Sniff(strings.NewReader("data"), opts); !errors.Is(err, ErrInternal) {
+		t.Errorf("expected an internal error sniffing, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected the panic to propagate without RecoverPanics")
+		}
+	}()
+	r.IsAnimated(context.Background(), strings.NewReader("BOOM"), nil)
+}
+
+// panicSniffer is a format whose Sniffer panics.
+type panicSniffer struct {
+	Format
+}
+
+func (panicSniffer) Name() string                               { return "panic" }
+func (panicSniffer) Sniff(peek func(n int) ([]byte, error)) int { panic("sniff") }
+
+func TestRegistryFirstFrame(t *testing.T) {
+	r := &Registry{}
+	r.Register(&funcFormat{"img", "IMG",
+		func(context.Context, io.Reader, *Options) (bool, error) {
+			return true, nil
+		},
+		func(_ context.Context, _ io.Reader, w io.Writer, _ *Options) error {
+			return png.Encode(w, image.NewGray(image.Rect(0, 0, 2, 3)))
+		},
+	})
+	registerFake(r, "txt", "TXT")
+
+	m, name, err := r.FirstFrame(context.Background(), strings.NewReader("IMG data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "img" || m.Bounds() != image.Rect(0, 0, 2, 3) {
+		t.Errorf("expected the 2x3 output of img to be decoded, got %v from %q", m.Bounds(), name)
+	}
+
+	if _, _, err := r.FirstFrame(context.Background(), strings.NewReader("TXT data"), nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for output that can't be decoded, got %v", err)
+	}
+}
+
+func TestRegistryInspect(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	if _, err := r.Inspect(context.Background(), strings.NewReader("AAA data"), nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a format that can't be inspected, got %v", err)
+	}
+}
+
+func TestRegistryRenderFrame(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	if _, err := r.RenderFrame(context.Background(), strings.NewReader("AAA data"), io.Discard, 0, nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a format that can't render frames, got %v", err)
+	}
+	if _, err := r.RenderFrameAt(context.Background(), strings.NewReader("AAA data"), io.Discard, time.Second, nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a format that can't render frames, got %v", err)
+	}
+	opts := &Options{Poster: PosterLast}
+	if _, err := r.RenderFirstFrame(context.Background(), strings.NewReader("AAA data"), io.Discard, opts); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a format that can't walk frames, got %v", err)
+	}
+}
+
+func TestRegistryDetect(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	d, err := r.Detect(context.Background(), strings.NewReader("AAA data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Format != "aaa" || d.State != Animated || !strings.Contains(d.Reason, "does not verify") {
+		t.Errorf("expected an unverified animated detection, got %+v", *d)
+	}
+}
+
+// countingFormat decides after it was written n bytes.
+type countingFormat struct {
+	funcFormat
+	n int
+}
+
+func (f *countingFormat) NewIncrementalDetector(*Options) IncrementalDetector {
+	c := *f
+	return &c
+}
+
+func (f *countingFormat) Write(p []byte) (*Detection, error) {
+	if f.n -= len(p); f.n > 0 {
+		return nil, nil
+	}
+	return &Detection{State: Static, Reason: "counted"}, nil
+}
+
+func TestRegistryDetector(t *testing.T) {
+	r := &Registry{}
+	registerFake(r, "aaa", "AAA")
+	r.Register(&countingFormat{funcFormat{name: "bbb", magic: "BBB"}, 12})
+
+	d := r.NewDetector(nil)
+	for _, p := range []string{"B", "BB", "data", "data", "more data"} {
+		if res, _ := d.Result(); res.State != Undetermined {
+			t.Fatalf("expected undetermined before %q, got %+v", p, *res)
+		}
+		if n, err := d.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf("expected to write %q, wrote %d, %v", p, n, err)
+		}
+	}
+	res, err := d.Result()
+	if err != nil || res.Format != "bbb" || res.State != Static {
+		t.Errorf("expected a static bbb detection, got %+v, %v", res, err)
+	}
+	if n, err := d.Write([]byte("after")); n != 5 || err != nil {
+		t.Errorf("expected writes after the detection to be accepted, wrote %d, %v", n, err)
+	}
+
+	if _, err := r.NewDetector(nil).Write([]byte("AAA")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a format without IncrementalVerifier, got %v", err)
+	}
+	d = r.NewDetector(&Options{Limits: &Limits{MaxBytes: 4}})
+	d.Write([]byte("BBB"))
+	if _, err := d.Write([]byte("BB")); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/image/riff"
+
+	"github.com/slackhq/deanimator"
+)
+
+var (
+	errNotRIFF          = fmt.Errorf("missing RIFF header: %w", deanimator.ErrMalformed)
+	errShortChunkHeader = fmt.Errorf("short chunk header: %w", deanimator.ErrMalformed)
+	errChunkTooLong     = fmt.Errorf("chunk too long: %w", deanimator.ErrMalformed)
+)
+
+// chunkReader reads the chunks of a RIFF form, or of a list of sub-chunks, one at a time. Unlike
+// riff.Reader, chunk data that is not read is passed over with deanimator.Discard, which seeks over
+// it when the input implements io.Seeker, and input that ends early is reported as such. It counts
+// the bytes passed, so errors can report their offset.
+type chunkReader struct {
+	r io.Reader
+	n int64
+
+	// remaining is the length of the form or list left after the current chunk.
+	remaining int64
+
+	// unread is the data of the current chunk that was not read, and pad its padding byte.
+	unread, pad int64
+}
+
+// newChunkReader reads the header of the RIFF form in r, which must be a WebP.
+func newChunkReader(r io.Reader) (*chunkReader, error) {
+	c := &chunkReader{r: r}
+	b := make([]byte, 12)
+	if err := c.readFull(b, "RIFF header"); err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != "RIFF" {
+		return nil, errNotRIFF
+	}
+	length := binary.LittleEndian.Uint32(b[4:8])
+	if length < 4 || string(b[8:]) != string(fccWEBP[:]) {
+		return nil, errMalformedImage
+	}
+	c.remaining = int64(length) - 4
+	return c, nil
+}
+
+// newListReader returns a chunkReader for the length bytes of sub-chunks read from r, such as the
+// data of an "ANMF" chunk after its header.
+func newListReader(r io.Reader, length uint32) *chunkReader {
+	return &chunkReader{r: r, remaining: int64(length)}
+}
+
+// next passes over what is left of the current chunk, and returns the next one. It returns io.EOF
+// once the form or list ends.
+func (c *chunkReader) next() (riff.FourCC, uint32, io.Reader, error) {
+	if skip := c.unread + c.pad; skip > 0 {
+		n, err := deanimator.Discard(c.r, skip)
+		c.n += n
+		if err == io.EOF && n < c.unread {
+			return riff.FourCC{}, 0, nil, truncated("chunk data", skip-n)
+		} else if err == io.EOF {
+			return riff.FourCC{}, 0, nil, truncated("padding byte", skip-n)
+		} else if err != nil {
+			return riff.FourCC{}, 0, nil, err
+		}
+		c.unread, c.pad = 0, 0
+	}
+	switch {
+	case c.remaining == 0:
+		return riff.FourCC{}, 0, nil, io.EOF
+	case c.remaining < 8:
+		return riff.FourCC{}, 0, nil, errShortChunkHeader
+	}
+
+	b := make([]byte, 8)
+	if err := c.readFull(b, "chunk header"); err != nil {
+		return riff.FourCC{}, 0, nil, err
+	}
+	var chunkID riff.FourCC
+	copy(chunkID[:], b[:4])
+	chunkLen := binary.LittleEndian.Uint32(b[4:])
+	// chunks are padded to an even length
+	c.unread, c.pad = int64(chunkLen), int64(chunkLen&1)
+	c.remaining -= 8 + c.unread + c.pad
+	if c.remaining < 0 {
+		return riff.FourCC{}, 0, nil, errChunkTooLong
+	}
+	return chunkID, chunkLen, chunkData{c}, nil
+}
+
+// readFull reads len(b) bytes of what, which the input must not end before.
+func (c *chunkReader) readFull(b []byte, what string) error {
+	n, err := io.ReadFull(c.r, b)
+	c.n += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return truncated(what, int64(len(b)-n))
+	}
+	return err
+}
+
+// truncated returns the error for input that ended need bytes before the end of what.
+func truncated(what string, need int64) error {
+	return fmt.Errorf("short %s: %w", what, deanimator.NeedMoreData(need))
+}
+
+// parseError wraps err in a *deanimator.ParseError for the chunk starting at offset, an empty chunk
+// is not named in the error.
+func parseError(offset int64, chunk riff.FourCC, err error) error {
+	name := ""
+	if chunk != (riff.FourCC{}) {
+		name = string(chunk[:])
+	}
+	return &deanimator.ParseError{Format: "webp", Offset: offset, Chunk: name, Err: err}
+}
+
+// chunkData reads the data of the current chunk of a chunkReader.
+type chunkData struct {
+	c *chunkReader
+}
+
+func (d chunkData) Read(p []byte) (int, error) {
+	c := d.c
+	if c.unread == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.unread {
+		p = p[:c.unread]
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.unread -= int64(n)
+	if err == io.EOF && c.unread > 0 {
+		return n, truncated("chunk data", c.unread)
+	} else if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
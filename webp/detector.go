@@ -0,0 +1,126 @@
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/image/riff"
+
+	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/internal/incremental"
+)
+
+// NewIncrementalDetector returns a deanimator.SkippingDetector verifying the animation of a WebP
+// written to it like VerifyAnimated does. Chunk data is skipped as it is written, only chunk headers
+// are buffered. It enforces the MaxChunkSize limit of opts.
+func NewIncrementalDetector(opts *deanimator.Options) deanimator.IncrementalDetector {
+	d := &detector{opts: opts}
+	d.p = incremental.New(12, d.header)
+	return d
+}
+
+type detector struct {
+	p      *incremental.Parser
+	opts   *deanimator.Options
+	result *deanimator.Detection
+	first  bool
+	frames int
+
+	// remaining is the length of the RIFF form left after the chunk being read.
+	remaining int64
+}
+
+func (d *detector) Write(b []byte) (*deanimator.Detection, error) {
+	if err := d.p.Write(b); err != nil {
+		return nil, err
+	}
+	return d.result, nil
+}
+
+func (d *detector) Skip() int64 { return d.p.TakeSkip() }
+func (d *detector) Need() int   { return d.p.Need() }
+
+func (d *detector) parseError(chunk riff.FourCC, err error) error {
+	name := ""
+	if chunk != (riff.FourCC{}) {
+		name = string(chunk[:])
+	}
+	return &deanimator.ParseError{Format: "webp", Offset: d.p.Offset(), Chunk: name, Err: err}
+}
+
+func (d *detector) static(reason string) error {
+	d.result = &deanimator.Detection{Format: "webp", State: deanimator.Static, Reason: reason}
+	return nil
+}
+
+func (d *detector) header(b []byte) error {
+	if string(b[:4]) != "RIFF" || string(b[8:]) != string(fccWEBP[:]) {
+		return d.parseError(riff.FourCC{}, errMalformedImage)
+	}
+	d.remaining = int64(binary.LittleEndian.Uint32(b[4:8])) - 4
+	d.first = true
+	return d.next()
+}
+
+// next reads the next chunk header, or decides once the RIFF form ends.
+func (d *detector) next() error {
+	switch {
+	case d.remaining == 0 && d.first:
+		return d.parseError(riff.FourCC{}, errMalformedImage)
+	case d.remaining == 0:
+		return d.static(fmt.Sprintf("VP8X animation flag set but only %d ANMF frame(s)", d.frames))
+	case d.remaining < 8:
+		return d.parseError(riff.FourCC{}, errMalformedImage)
+	}
+	d.p.Read(8, d.chunkHeader)
+	return nil
+}
+
+func (d *detector) chunkHeader(b []byte) error {
+	var chunkID riff.FourCC
+	copy(chunkID[:], b[:4])
+	chunkLen := binary.LittleEndian.Uint32(b[4:])
+	if err := d.opts.CheckChunkSize(int64(chunkLen)); err != nil {
+		return d.parseError(chunkID, err)
+	}
+	// chunks are padded to an even length
+	padded := int64(chunkLen) + int64(chunkLen&1)
+	if 8+padded > d.remaining {
+		return d.parseError(chunkID, errMalformedImage)
+	}
+	d.remaining -= 8 + padded
+
+	first := d.first
+	d.first = false
+	switch {
+	case first && (chunkID == fccVP8 || chunkID == fccVP8L):
+		return d.static(fmt.Sprintf("simple format %s image", strings.TrimSpace(string(chunkID[:]))))
+	case first && chunkID == fccVP8X:
+		if chunkLen < 1 {
+			return d.parseError(chunkID, errMalformedImage)
+		}
+		d.p.Read(1, func(flags []byte) error {
+			if flags[0]&2 == 0 {
+				return d.static("VP8X animation flag not set")
+			}
+			d.p.Skip(padded - 1)
+			return d.next()
+		})
+		return nil
+	case first:
+		return d.parseError(chunkID, errMalformedImage)
+	case chunkID == fccANMF:
+		d.frames++
+		if d.frames == 2 {
+			d.result = &deanimator.Detection{
+				Format: "webp",
+				State:  deanimator.Animated,
+				Reason: "VP8X animation flag set and a second ANMF frame follows",
+			}
+			return nil
+		}
+	}
+	d.p.Skip(padded)
+	return d.next()
+}
@@ -0,0 +1,227 @@
+package webp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/riff"
+
+	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/internal/animation"
+	"github.com/slackhq/deanimator/webp/internal/decoder"
+)
+
+// RenderFrame writes frame index of the WebP in r to w as a PNG of the canvas, composited the way
+// browsers display it: frames are blended over the frames before them unless their "ANMF" chunk
+// says otherwise, and are cleared once the next frame is drawn if it asks for it. The first frame
+// has index 0. A still WebP only has frame 0.
+func RenderFrame(r io.Reader, w io.Writer, index int) error {
+	return RenderFrameContext(context.Background(), r, w, index, nil)
+}
+
+// RenderFrameContext is like RenderFrame, but checks ctx between chunks and returns ctx.Err() once
+// it is done. The canvas starts out transparent, and frames are cleared to transparent, unless
+// Options.FillBackground is set. It enforces the limits of opts. An index past the last frame
+// returns an error wrapping deanimator.ErrFrameIndex.
+func RenderFrameContext(ctx context.Context, r io.Reader, w io.Writer, index int, opts *deanimator.Options) error {
+	p, err := newPlayer(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	if err := p.skipTo(index); err != nil {
+		return err
+	}
+	return png.Encode(w, p.canvas.Image())
+}
+
+// WalkFrames calls fn with each frame of the WebP in r, composited onto the canvas like RenderFrame
+// does. m is only valid until fn returns, and an error returned by fn stops the walk and is
+// returned as is.
+func WalkFrames(r io.Reader, fn func(m image.Image) error) error {
+	return WalkFramesContext(context.Background(), r, nil, fn)
+}
+
+// WalkFramesContext is like WalkFrames, but stops with ctx.Err() once ctx is done. It enforces the
+// limits of opts like RenderFrameContext.
+func WalkFramesContext(ctx context.Context, r io.Reader, opts *deanimator.Options, fn func(m image.Image) error) error {
+	p, err := newPlayer(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := p.next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(p.canvas.Image()); err != nil {
+			return err
+		}
+	}
+}
+
+// player composites the frames of a WebP onto its canvas one at a time.
+type player struct {
+	ctx  context.Context
+	r    *chunkReader
+	opts *deanimator.Options
+
+	// still is the image of a still WebP, drawn as its only frame.
+	still image.Image
+
+	canvas *animation.Canvas
+	anmfs  int
+	frames int // the number of frames drawn
+}
+
+func newPlayer(ctx context.Context, src io.Reader, opts *deanimator.Options) (*player, error) {
+	src = opts.LimitReader(src)
+	p := &player{ctx: ctx, opts: opts}
+
+	// keep what is read of the first chunk, which is the "VP8X" chunk flagging an animation, so a
+	// still image can be decoded as it is
+	read := bytes.NewBuffer([]byte{})
+	r, err := newChunkReader(io.TeeReader(src, read))
+	if err != nil {
+		return nil, parseError(0, riff.FourCC{}, err)
+	}
+	chunkID, chunkLen, chunkData, err := r.next()
+	if err != nil {
+		return nil, parseError(r.n, riff.FourCC{}, err)
+	}
+	offset := r.n - 8
+	var data []byte
+	if chunkID == fccVP8X {
+		if data, err = readChunkHeader(chunkData, chunkLen, 10); err != nil {
+			return nil, parseError(offset, chunkID, err)
+		}
+	}
+	if data == nil || data[0]&2 == 0 {
+		m, err := decoder.Decode(deanimator.ContextReader(ctx, io.MultiReader(read, src)))
+		if err != nil {
+			return nil, err
+		}
+		p.still = m
+		p.canvas = animation.NewCanvas(m.Bounds().Dx(), m.Bounds().Dy(), color.Transparent)
+		return p, nil
+	}
+	// the rest is read, or seeked over, from src itself
+	r.r = src
+	p.r = r
+
+	// the canvas size is stored as 24 bit values minus one
+	width, height := 1+u24(data[4:7]), 1+u24(data[7:10])
+	if err := opts.CheckPixels(width, height); err != nil {
+		return nil, parseError(offset, chunkID, err)
+	}
+	p.canvas = animation.NewCanvas(width, height, color.Transparent)
+	return p, nil
+}
+
+// next draws the next frame onto the canvas and returns its "ANMF" header. It returns io.EOF after
+// the last frame.
+func (p *player) next() (*anmfHeader, error) {
+	if p.still != nil {
+		if p.frames > 0 {
+			return nil, io.EOF
+		}
+		p.canvas.Draw(p.still, image.Point{}, false, animation.DisposeNone)
+		p.frames++
+		return &anmfHeader{width: p.still.Bounds().Dx(), height: p.still.Bounds().Dy()}, nil
+	}
+
+	for {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		chunkID, chunkLen, chunkData, err := p.r.next()
+		if err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, parseError(p.r.n, riff.FourCC{}, err)
+		}
+		offset := p.r.n - 8
+		switch chunkID {
+		case fccANIM:
+			if !options(p.opts).FillBackground {
+				break
+			}
+			// the background color is stored in blue, green, red, alpha order
+			data, err := readChunkHeader(chunkData, chunkLen, 4)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			bounds := p.canvas.Image().Rect
+			p.canvas = animation.NewCanvas(bounds.Dx(), bounds.Dy(), color.NRGBA{R: data[2], G: data[1], B: data[0], A: data[3]})
+			continue
+		case fccANMF:
+			header, err := p.draw(chunkLen, chunkData)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			return header, nil
+		}
+		// other chunks, like metadata, are passed over by the next call to p.r.next
+	}
+}
+
+// draw decodes the frame of an "ANMF" chunk and draws it onto the canvas.
+func (p *player) draw(chunkLen uint32, chunkData io.Reader) (*anmfHeader, error) {
+	p.anmfs++
+	if err := p.opts.CheckFrames(p.anmfs); err != nil {
+		return nil, err
+	}
+	if err := p.opts.CheckChunkSize(int64(chunkLen)); err != nil {
+		return nil, err
+	}
+	data, err := readChunkHeader(chunkData, chunkLen, 16)
+	if err != nil {
+		return nil, err
+	}
+	header := parseANMFHeader(data)
+	bounds := p.canvas.Image().Rect
+	if header.x+header.width > bounds.Dx() || header.y+header.height > bounds.Dy() {
+		return nil, fmt.Errorf("frame outside of the canvas: %w", deanimator.ErrMalformed)
+	}
+	bitstream, hasAlpha, err := readANMFBitstream(p.ctx, chunkLen-16, chunkData, p.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	still := bytes.NewBuffer([]byte{})
+	if err := writeStill(still, header.width, header.height, bitstream, hasAlpha); err != nil {
+		return nil, err
+	}
+	m, err := decoder.Decode(still)
+	if err != nil {
+		return nil, err
+	}
+	dispose := animation.DisposeNone
+	if header.disposeBackground {
+		dispose = animation.DisposeBackground
+	}
+	p.canvas.Draw(m, image.Point{X: header.x, Y: header.y}, !header.noBlend, dispose)
+	p.frames++
+	return &header, nil
+}
+
+// skipTo draws the frames up to frame index.
+func (p *player) skipTo(index int) error {
+	if index < 0 {
+		return fmt.Errorf("webp: frame %d: %w", index, deanimator.ErrFrameIndex)
+	}
+	for p.frames <= index {
+		if _, err := p.next(); err == io.EOF {
+			return fmt.Errorf("webp: frame %d of %d frames: %w", index, p.frames, deanimator.ErrFrameIndex)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
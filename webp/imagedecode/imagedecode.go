@@ -0,0 +1,32 @@
+// Package imagedecode registers a WebP decoder with the image package that supports animated
+// images, decoding their first frame. Import it for its side effect:
+//
+//	import _ "github.com/slackhq/deanimator/webp/imagedecode"
+//
+// It registers under the same name and magic as golang.org/x/image/webp, which rejects animated
+// images, so only one of the two packages should be imported: image.Decode uses whichever was
+// registered first.
+package imagedecode
+
+import (
+	"image"
+	"io"
+
+	"github.com/slackhq/deanimator/webp"
+)
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBPVP8", Decode, DecodeConfig)
+}
+
+// Decode reads a WebP image from r and returns its first frame. Still images are decoded as they
+// are.
+func Decode(r io.Reader) (image.Image, error) {
+	return webp.FirstFrame(r)
+}
+
+// DecodeConfig returns the color model and canvas size of a WebP image. For animated images it
+// only reads as far as the first frame.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	return webp.FirstFrameConfig(r)
+}
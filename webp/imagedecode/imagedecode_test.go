@@ -0,0 +1,42 @@
+package imagedecode
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		file         string
+		expectBounds image.Rectangle
+	}{
+		{"animated.webp", image.Rect(0, 0, 400, 400)},
+		{"house.webp", image.Rect(0, 0, 1536, 1024)},
+		{"yellowrose-lossy-alpha.webp", image.Rect(0, 0, 400, 301)},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile("../../testdata/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, format, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != "webp" || m.Bounds() != tc.expectBounds {
+				t.Errorf("expected a webp with bounds %v, got a %s with bounds %v", tc.expectBounds, format, m.Bounds())
+			}
+
+			c, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Width != tc.expectBounds.Dx() || c.Height != tc.expectBounds.Dy() || c.ColorModel != m.ColorModel() {
+				t.Errorf("expected the config to match the decoded image, got %+v", c)
+			}
+		})
+	}
+}
@@ -3,14 +3,21 @@ package webp
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"strings"
+	"time"
 
 	"golang.org/x/image/riff"
 
 	"github.com/slackhq/deanimator"
+	"github.com/slackhq/deanimator/internal/animation"
+	"github.com/slackhq/deanimator/webp/internal/decoder"
 )
 
 var (
@@ -23,30 +30,59 @@ var (
 	fccANMF = riff.FourCC{'A', 'N', 'M', 'F'}
 )
 
+// Options holds the webp specific options of a single call, set it under "webp" in
+// deanimator.Options.FormatOptions.
+type Options struct {
+	// FillBackground makes FirstFrame, RenderFirstFrame and RenderFrame fill the canvas around the
+	// frames with the background color of the "ANIM" chunk. By default it is left transparent, as browsers do.
+	FillBackground bool
+}
+
+func options(opts *deanimator.Options) *Options {
+	if o, ok := opts.FormatOption("webp").(*Options); ok && o != nil {
+		return o
+	}
+	return &Options{}
+}
+
 var (
-	errMalformedImage = errors.New("webp malformed, unable to process")
+	errMalformedImage = fmt.Errorf("webp malformed, unable to process: %w", deanimator.ErrMalformed)
 )
 
+// u24 decodes the first three bytes of b as a little-endian integer.
+func u24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
 func IsAnimated(src io.Reader) (bool, error) {
-	formType, r, err := riff.NewReader(src)
-	if err != nil {
-		return false, fmt.Errorf("cannot create reader: %w", errMalformedImage)
+	return IsAnimatedContext(context.Background(), src, nil)
+}
+
+// IsAnimatedContext is like IsAnimated, but returns ctx.Err() if ctx is done before the answer is
+// known. It enforces the MaxBytes limit of opts.
+func IsAnimatedContext(ctx context.Context, src io.Reader, opts *deanimator.Options) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
-	if formType != fccWEBP {
-		return false, errMalformedImage
+	r, err := newChunkReader(opts.LimitReader(src))
+	if err != nil {
+		return false, parseError(0, riff.FourCC{}, err)
 	}
 
-	chunkID, _, chunkData, err := r.Next()
+	chunkID, chunkLen, chunkData, err := r.next()
 	if err != nil {
+		return false, parseError(r.n, riff.FourCC{}, err)
+	}
+	if err := ctx.Err(); err != nil {
 		return false, err
 	}
 	if chunkID != fccVP8X {
 		return false, nil
 	}
-	extended := []byte{0}
-	_, err = chunkData.Read(extended)
+	offset := r.n - 8
+	extended, err := readChunkHeader(chunkData, chunkLen, 1)
 	if err != nil {
-		return false, err
+		return false, parseError(offset, chunkID, err)
 	}
 	animation := extended[0]&byte(2) == byte(2)
 
@@ -77,99 +113,208 @@ For a still image, the image data consists of a single frame, which is made up o
 +- EXIF (metadata)
 */
 
+// RenderFirstFrame writes the first frame of an animated WebP to dst as a still WebP. A first frame
+// that does not cover the canvas cannot be placed on it without re-encoding it, so it is drawn onto
+// the canvas like FirstFrame does and written as a PNG instead.
 func RenderFirstFrame(src io.Reader, dst io.Writer) error {
-	formType, r, err := riff.NewReader(src)
+	_, err := RenderFirstFrameContext(context.Background(), src, dst, nil)
+	return err
+}
+
+// RenderFirstFrameContext is like RenderFirstFrame, but checks ctx between chunks and returns
+// ctx.Err() once it is done. A still WebP returns deanimator.ErrNotAnimated. It enforces the
+// MaxBytes, MaxChunkSize and MaxPixels limits of opts.
+func RenderFirstFrameContext(ctx context.Context, src io.Reader, dst io.Writer, opts *deanimator.Options) (*deanimator.Result, error) {
+	f, err := readFirstFrame(ctx, src, opts)
 	if err != nil {
-		return errMalformedImage
+		return nil, err
+	}
+	res := &deanimator.Result{
+		InputFormat:  "webp",
+		OutputFormat: "webp",
+		MIMEType:     "image/webp",
+		Width:        f.canvasWidth,
+		Height:       f.canvasHeight,
 	}
-	if formType != fccWEBP {
-		return errMalformedImage
+	fillBackground := options(opts).FillBackground
+	if f.covers() && !fillBackground {
+		return res, writeStill(dst, f.width, f.height, f.bitstream, f.hasAlpha)
 	}
-	var canvasSize []byte
+
+	m, err := f.decode()
+	if err != nil {
+		return nil, err
+	}
+	res.OutputFormat, res.MIMEType = "png", "image/png"
+	return res, png.Encode(dst, f.composite(m, fillBackground))
+}
+
+// anmfHeader is the header of an "ANMF" chunk, which places a frame on the canvas.
+type anmfHeader struct {
+	x, y, width, height int
+
+	// duration is in milliseconds.
+	duration int
+
+	// noBlend replaces the canvas under the frame with it, instead of alpha blending the frame
+	// over it.
+	noBlend bool
+
+	// disposeBackground clears the area of the frame to the background color after the frame
+	// is displayed.
+	disposeBackground bool
+}
+
+func parseANMFHeader(b []byte) anmfHeader {
+	return anmfHeader{
+		// the offset is stored halved, the size minus one
+		x:                 2 * u24(b[0:3]),
+		y:                 2 * u24(b[3:6]),
+		width:             1 + u24(b[6:9]),
+		height:            1 + u24(b[9:12]),
+		duration:          u24(b[12:15]),
+		noBlend:           b[15]&2 != 0,
+		disposeBackground: b[15]&1 != 0,
+	}
+}
+
+// firstFrame is the first frame of an animated WebP, as read by readFirstFrame.
+type firstFrame struct {
+	anmfHeader
+
+	canvasWidth, canvasHeight int
+	background                color.NRGBA
+
+	// bitstream holds the alpha and bitstream sub-chunks of the frame.
+	bitstream []byte
+	hasAlpha  bool
+}
+
+// readFirstFrame reads the first "ANMF" frame of an animated WebP. A still WebP returns
+// deanimator.ErrNotAnimated.
+func readFirstFrame(ctx context.Context, src io.Reader, opts *deanimator.Options) (*firstFrame, error) {
+	r, err := newChunkReader(opts.LimitReader(src))
+	if err != nil {
+		return nil, parseError(0, riff.FourCC{}, err)
+	}
+	var f *firstFrame
 	for {
-		chunkID, chunkLen, chunkData, err := r.Next()
-		if err != nil {
-			return fmt.Errorf("unable to get next chunk: %w", err)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		chunkID, chunkLen, chunkData, err := r.next()
+		if err == io.EOF {
+			// there is no frame
+			return nil, parseError(r.n, riff.FourCC{}, errMalformedImage)
+		} else if err != nil {
+			return nil, parseError(r.n, riff.FourCC{}, err)
 		}
+		offset := r.n - 8
 		switch chunkID {
 		case fccVP8X:
-			extended := []byte{0}
-			_, err = chunkData.Read(extended)
+			// the flags, three reserved bytes and the canvas size
+			data, err := readChunkHeader(chunkData, chunkLen, 10)
 			if err != nil {
-				return err
+				return nil, parseError(offset, chunkID, err)
 			}
-			animation := extended[0]&byte(2) == byte(2)
+			animation := data[0]&byte(2) == byte(2)
 			if !animation {
-				return fmt.Errorf("not an animated image")
+				return nil, deanimator.ErrNotAnimated
 			}
-			reserved := []byte{0, 0, 0}
-			_, err = chunkData.Read(reserved)
+			// the canvas size is stored as 24 bit values minus one
+			f = &firstFrame{canvasWidth: 1 + u24(data[4:7]), canvasHeight: 1 + u24(data[7:10])}
+			err = opts.CheckPixels(f.canvasWidth, f.canvasHeight)
 			if err != nil {
-				return fmt.Errorf("unable to read reserved: %w", err)
+				return nil, parseError(offset, chunkID, err)
 			}
-			canvasSize = []byte{0, 0, 0, 0, 0, 0}
-			_, err = chunkData.Read(canvasSize)
+		case fccVP8, fccVP8L:
+			// a simple format image, there is no VP8X chunk to flag an animation
+			return nil, deanimator.ErrNotAnimated
+		case fccANIM:
+			if f == nil {
+				return nil, parseError(offset, chunkID, errMalformedImage)
+			}
+			// the background color is stored in blue, green, red, alpha order
+			data, err := readChunkHeader(chunkData, chunkLen, 4)
 			if err != nil {
-				return fmt.Errorf("unable to read canvas size: %w", err)
+				return nil, parseError(offset, chunkID, err)
 			}
-		case fccANIM:
-			// do nothing
+			f.background = color.NRGBA{R: data[2], G: data[1], B: data[0], A: data[3]}
 		case fccANMF:
-			discard := make([]byte, 16)
-			_, err := chunkData.Read(discard)
-			if err != nil {
-				return fmt.Errorf("unable to discard ANMF data: %w", err)
+			if f == nil || chunkLen < 16 {
+				return nil, parseError(offset, chunkID, errMalformedImage)
 			}
-			bitstream, hasAlpha, err := readANMFBitstream(chunkLen-16, chunkData)
-			if err != nil {
-				return fmt.Errorf("unable to read ANMF bitstream: %w", err)
+			if err := opts.CheckChunkSize(int64(chunkLen)); err != nil {
+				return nil, parseError(offset, chunkID, err)
 			}
-
-			io.WriteString(dst, "RIFF")
-
-			fileSize := 4 + //webp
-				8 + //vp8x header
-				10 + // vp8x len
-				len(bitstream) //first frame data
-			err = binary.Write(dst, binary.LittleEndian, uint32(fileSize))
+			header := make([]byte, 16)
+			_, err := io.ReadFull(chunkData, header)
 			if err != nil {
-				return fmt.Errorf("unable to write file size: %w", err)
+				return nil, parseError(offset, chunkID, err)
 			}
-
-			dst.Write(fccWEBP[:])
-
-			// VP8X chunk
-			dst.Write(fccVP8X[:])
-			err = binary.Write(dst, binary.LittleEndian, uint32(10))
-			if err != nil {
-				return fmt.Errorf("unable to write vp8x chunk size: %w", err)
+			f.anmfHeader = parseANMFHeader(header)
+			if f.x+f.width > f.canvasWidth || f.y+f.height > f.canvasHeight {
+				return nil, parseError(offset, chunkID, fmt.Errorf("frame outside of the canvas: %w", deanimator.ErrMalformed))
 			}
-
-			extended := byte(0)
-			if hasAlpha {
-				extended = byte(16)
+			f.bitstream, f.hasAlpha, err = readANMFBitstream(ctx, chunkLen-16, chunkData, opts)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
 			}
-			dst.Write([]byte{extended})
-			dst.Write([]byte{0, 0, 0}) // reserved
-			dst.Write(canvasSize)
-
-			dst.Write(bitstream)
-
-			return nil
+			return f, nil
 		default:
-			return errMalformedImage
+			if f == nil {
+				return nil, parseError(offset, chunkID, errMalformedImage)
+			}
+			// other chunks, like "ICCP" or metadata, are passed over by the next call to r.next
 		}
 	}
 }
 
-func readANMFBitstream(anmfChunkLen uint32, anmfChunkData io.Reader) ([]byte, bool, error) {
-	_, r, err := riff.NewListReader(anmfChunkLen+4, io.MultiReader(
-		bytes.NewReader(fccANMF[:]),
-		anmfChunkData,
-	))
+// writeStill writes a still WebP of the given size made of the alpha and bitstream sub-chunks in
+// bitstream.
+func writeStill(dst io.Writer, width, height int, bitstream []byte, hasAlpha bool) error {
+	io.WriteString(dst, "RIFF")
+
+	fileSize := 4 + //webp
+		8 + //vp8x header
+		10 + // vp8x len
+		len(bitstream) //first frame data
+	err := binary.Write(dst, binary.LittleEndian, uint32(fileSize))
+	if err != nil {
+		return fmt.Errorf("unable to write file size: %w", err)
+	}
+
+	dst.Write(fccWEBP[:])
+
+	// VP8X chunk
+	dst.Write(fccVP8X[:])
+	err = binary.Write(dst, binary.LittleEndian, uint32(10))
 	if err != nil {
-		return nil, false, errMalformedImage
+		return fmt.Errorf("unable to write vp8x chunk size: %w", err)
 	}
+
+	extended := byte(0)
+	if hasAlpha {
+		extended = byte(16)
+	}
+	dst.Write([]byte{extended})
+	dst.Write([]byte{0, 0, 0}) // reserved
+	// the canvas size is stored as 24 bit values minus one
+	dst.Write([]byte{
+		byte(width - 1), byte((width - 1) >> 8), byte((width - 1) >> 16),
+		byte(height - 1), byte((height - 1) >> 8), byte((height - 1) >> 16),
+	})
+
+	_, err = dst.Write(bitstream)
+	return err
+}
+
+// readANMFBitstream buffers the alpha and bitstream sub-chunks of an "ANMF" chunk, the
+// anmfChunkLen bytes after its header read from anmfChunkData. Sub-chunks larger than the
+// MaxChunkSize limit of opts are not buffered.
+func readANMFBitstream(ctx context.Context, anmfChunkLen uint32, anmfChunkData io.Reader, opts *deanimator.Options) ([]byte, bool, error) {
+	r := newListReader(anmfChunkData, anmfChunkLen)
 	// TODO: this should write using io.Copy or similar to the upstream writer
 	// and not this intermediate buffer. Since we need to know the length though
 	// that may not be possible and we have to buffer I guess? Did we get the
@@ -177,7 +322,11 @@ func readANMFBitstream(anmfChunkLen uint32, anmfChunkData io.Reader) ([]byte, bo
 	bitstream := bytes.NewBuffer([]byte{})
 	hasAlpha := false
 	for {
-		chunkID, chunkLen, chunkData, err := r.Next()
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		chunkID, chunkLen, chunkData, err := r.next()
 		if err == io.EOF {
 			return bitstream.Bytes(), hasAlpha, nil
 		}
@@ -190,6 +339,9 @@ func readANMFBitstream(anmfChunkLen uint32, anmfChunkData io.Reader) ([]byte, bo
 
 			fallthrough
 		case fccVP8, fccVP8L:
+			if err := opts.CheckChunkSize(int64(chunkLen)); err != nil {
+				return nil, false, err
+			}
 			bitstream.Write(chunkID[:])
 			err = binary.Write(bitstream, binary.LittleEndian, chunkLen)
 			if err != nil {
@@ -210,6 +362,343 @@ func readANMFBitstream(anmfChunkLen uint32, anmfChunkData io.Reader) ([]byte, bo
 	}
 }
 
+// FirstFrame decodes the first frame of an animated WebP, drawn onto the canvas. The canvas around
+// the frame is transparent, unless Options.FillBackground is set. A still WebP is its own first
+// frame.
+func FirstFrame(src io.Reader) (image.Image, error) {
+	return FirstFrameContext(context.Background(), src, nil)
+}
+
+// FirstFrameContext is like FirstFrame, but checks ctx between chunks and returns ctx.Err() once it
+// is done. It enforces the limits of opts like RenderFirstFrameContext.
+func FirstFrameContext(ctx context.Context, src io.Reader, opts *deanimator.Options) (image.Image, error) {
+	src = opts.LimitReader(src)
+	read := bytes.NewBuffer([]byte{})
+	f, err := readFirstFrame(ctx, io.TeeReader(src, read), opts)
+	if err == deanimator.ErrNotAnimated {
+		// a still image is decoded as it is
+		_, err = io.Copy(read, deanimator.ContextReader(ctx, src))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.Decode(read)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := f.decode()
+	if err != nil {
+		return nil, err
+	}
+	return f.composite(m, options(opts).FillBackground), nil
+}
+
+// FirstFrameConfig returns the color model and size of the image FirstFrame decodes, only reading
+// as far as the first frame of an animated WebP.
+func FirstFrameConfig(src io.Reader) (image.Config, error) {
+	read := bytes.NewBuffer([]byte{})
+	f, err := readFirstFrame(context.Background(), io.TeeReader(src, read), nil)
+	if err == deanimator.ErrNotAnimated {
+		// a still image has its configuration up front
+		return decoder.DecodeConfig(io.MultiReader(read, src))
+	} else if err != nil {
+		return image.Config{}, err
+	}
+	if !f.covers() {
+		return image.Config{ColorModel: color.RGBAModel, Width: f.canvasWidth, Height: f.canvasHeight}, nil
+	}
+	still := bytes.NewBuffer([]byte{})
+	if err := writeStill(still, f.width, f.height, f.bitstream, f.hasAlpha); err != nil {
+		return image.Config{}, err
+	}
+	return decoder.DecodeConfig(still)
+}
+
+// covers reports whether the frame covers the whole canvas.
+func (f *firstFrame) covers() bool {
+	return f.x == 0 && f.y == 0 && f.width == f.canvasWidth && f.height == f.canvasHeight
+}
+
+// decode decodes the bitstream of the frame.
+func (f *firstFrame) decode() (image.Image, error) {
+	still := bytes.NewBuffer([]byte{})
+	if err := writeStill(still, f.width, f.height, f.bitstream, f.hasAlpha); err != nil {
+		return nil, err
+	}
+	return decoder.Decode(still)
+}
+
+// composite draws the decoded frame m onto the canvas. The canvas starts out transparent, or filled
+// with the background color if fillBackground is set. A frame covering the whole canvas is returned
+// as is.
+func (f *firstFrame) composite(m image.Image, fillBackground bool) image.Image {
+	if f.covers() && !fillBackground {
+		return m
+	}
+	background := color.Color(color.Transparent)
+	if fillBackground {
+		background = f.background
+	}
+	c := animation.NewCanvas(f.canvasWidth, f.canvasHeight, background)
+	c.Draw(m, image.Point{X: f.x, Y: f.y}, !f.noBlend, animation.DisposeNone)
+	return c.Image()
+}
+
+// VerifyAnimated reports the WebP in r as animated if its "VP8X" chunk has the animation flag set
+// and a second "ANMF" frame follows, so the frames exist. It reads up to the second "ANMF" chunk.
+// It enforces the MaxBytes and MaxChunkSize limits of opts.
+func VerifyAnimated(ctx context.Context, src io.Reader, opts *deanimator.Options) (*deanimator.Detection, error) {
+	r, err := newChunkReader(opts.LimitReader(src))
+	if err != nil {
+		return nil, parseError(0, riff.FourCC{}, err)
+	}
+
+	static := func(reason string) (*deanimator.Detection, error) {
+		return &deanimator.Detection{Format: "webp", State: deanimator.Static, Reason: reason}, nil
+	}
+	frames := 0
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		chunkID, chunkLen, chunkData, err := r.next()
+		if err == io.EOF && !first {
+			return static(fmt.Sprintf("VP8X animation flag set but only %d ANMF frame(s)", frames))
+		} else if err == io.EOF {
+			return nil, parseError(r.n, riff.FourCC{}, errMalformedImage)
+		} else if err != nil {
+			return nil, parseError(r.n, riff.FourCC{}, err)
+		}
+		offset := r.n - 8
+		if err := opts.CheckChunkSize(int64(chunkLen)); err != nil {
+			return nil, parseError(offset, chunkID, err)
+		}
+
+		switch {
+		case first && (chunkID == fccVP8 || chunkID == fccVP8L):
+			return static(fmt.Sprintf("simple format %s image", strings.TrimSpace(string(chunkID[:]))))
+		case first && chunkID == fccVP8X:
+			data, err := readChunkHeader(chunkData, chunkLen, 1)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			if data[0]&2 == 0 {
+				return static("VP8X animation flag not set")
+			}
+		case first:
+			return nil, parseError(offset, chunkID, errMalformedImage)
+		case chunkID == fccANMF:
+			frames++
+			if frames == 2 {
+				return &deanimator.Detection{
+					Format: "webp",
+					State:  deanimator.Animated,
+					Reason: "VP8X animation flag set and a second ANMF frame follows",
+				}, nil
+			}
+		}
+	}
+}
+
+// Inspect reads the chunks of the WebP in r without decoding bitstreams, and describes it. The
+// animation of an animated WebP is described by its "ANIM" and "ANMF" chunks. Other chunks are
+// seeked over when src implements io.Seeker. It enforces the MaxBytes, MaxChunkSize and MaxFrames
+// limits of opts.
+func Inspect(ctx context.Context, src io.Reader, opts *deanimator.Options) (*deanimator.Info, error) {
+	r, err := newChunkReader(opts.LimitReader(src))
+	if err != nil {
+		return nil, parseError(0, riff.FourCC{}, err)
+	}
+
+	info := &deanimator.Info{Format: "webp", Frames: 1, LoopCount: 1}
+	animated := false
+	var delays []time.Duration
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		chunkID, chunkLen, chunkData, err := r.next()
+		if err == io.EOF && !first {
+			break
+		} else if err == io.EOF {
+			return nil, parseError(r.n, riff.FourCC{}, errMalformedImage)
+		} else if err != nil {
+			return nil, parseError(r.n, riff.FourCC{}, err)
+		}
+		offset := r.n - 8
+		if err := opts.CheckChunkSize(int64(chunkLen)); err != nil {
+			return nil, parseError(offset, chunkID, err)
+		}
+
+		switch {
+		case first && chunkID == fccVP8:
+			// a frame tag and start code, followed by 14 bit dimensions
+			data, err := readChunkHeader(chunkData, chunkLen, 10)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			info.Width = int(binary.LittleEndian.Uint16(data[6:8]) & 0x3fff)
+			info.Height = int(binary.LittleEndian.Uint16(data[8:10]) & 0x3fff)
+		case first && chunkID == fccVP8L:
+			// a signature byte, followed by 14 bit dimensions minus one and the alpha bit
+			data, err := readChunkHeader(chunkData, chunkLen, 5)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			bits := binary.LittleEndian.Uint32(data[1:5])
+			info.Width = 1 + int(bits&0x3fff)
+			info.Height = 1 + int(bits>>14&0x3fff)
+			info.HasAlpha = bits&(1<<28) != 0
+		case first && chunkID == fccVP8X:
+			data, err := readChunkHeader(chunkData, chunkLen, 10)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			animated = data[0]&2 != 0
+			info.HasAlpha = data[0]&16 != 0
+			info.Width = 1 + u24(data[4:7])
+			info.Height = 1 + u24(data[7:10])
+		case first:
+			return nil, parseError(offset, chunkID, errMalformedImage)
+		case chunkID == fccANIM:
+			// a background color, followed by the loop count
+			data, err := readChunkHeader(chunkData, chunkLen, 6)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			info.LoopCount = int(binary.LittleEndian.Uint16(data[4:6]))
+		case chunkID == fccANMF:
+			if err := opts.CheckFrames(len(delays) + 1); err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			// the frame position and size, followed by its duration in milliseconds
+			data, err := readChunkHeader(chunkData, chunkLen, 16)
+			if err != nil {
+				return nil, parseError(offset, chunkID, err)
+			}
+			delays = append(delays, time.Duration(u24(data[12:15]))*time.Millisecond)
+		}
+	}
+
+	if animated {
+		info.Frames = len(delays)
+		info.Delays = delays
+		for _, delay := range delays {
+			info.Duration += delay
+		}
+	}
+	return info, nil
+}
+
+// readChunkHeader reads the first n bytes of the data of a chunk, which must be at least that long.
+func readChunkHeader(chunkData io.Reader, chunkLen uint32, n int) ([]byte, error) {
+	if chunkLen < uint32(n) {
+		return nil, errMalformedImage
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(chunkData, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Validate reads the whole WebP in r and returns an error if its RIFF structure is not well
+// formed. The image must start with a "VP8 ", "VP8L" or "VP8X" chunk and the sub-chunks of every
+// "ANMF" frame are checked. Bitstreams are not decoded, and other chunks are seeked over when src
+// implements io.Seeker. It enforces the MaxBytes, MaxChunkSize and MaxFrames limits of opts.
+func Validate(ctx context.Context, src io.Reader, opts *deanimator.Options) error {
+	r, err := newChunkReader(opts.LimitReader(src))
+	if err != nil {
+		return parseError(0, riff.FourCC{}, err)
+	}
+	frames := 0
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunkID, chunkLen, chunkData, err := r.next()
+		if err == io.EOF && !first {
+			return nil
+		} else if err == io.EOF {
+			return parseError(r.n, riff.FourCC{}, errMalformedImage)
+		} else if err != nil {
+			return parseError(r.n, riff.FourCC{}, err)
+		}
+		offset := r.n - 8
+		if err := opts.CheckChunkSize(int64(chunkLen)); err != nil {
+			return parseError(offset, chunkID, err)
+		}
+
+		switch {
+		case first && chunkID != fccVP8 && chunkID != fccVP8L && chunkID != fccVP8X:
+			return parseError(offset, chunkID, errMalformedImage)
+		case chunkID == fccANMF:
+			if chunkLen < 16 {
+				return parseError(offset, chunkID, errMalformedImage)
+			}
+			frames++
+			if err := opts.CheckFrames(frames); err != nil {
+				return parseError(offset, chunkID, err)
+			}
+			if _, err := io.CopyN(io.Discard, chunkData, 16); err != nil {
+				return parseError(offset, chunkID, err)
+			}
+			if _, _, err := readANMFBitstream(ctx, chunkLen-16, chunkData, opts); err != nil {
+				return parseError(offset, chunkID, err)
+			}
+		}
+	}
+}
+
+// Format implements deanimator.Format, deanimator.AnimationVerifier, deanimator.FirstFrameDecoder,
+// deanimator.FrameRenderer, deanimator.FrameWalker, deanimator.Inspector,
+// deanimator.IncrementalVerifier and deanimator.Validator for WebP images. It is registered to
+// deanimator.DefaultRegistry when this package is imported.
+type Format struct{}
+
+func (Format) Name() string  { return "webp" }
+func (Format) Magic() string { return "RIFF????WEBPVP8" }
+
+func (Format) IsAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (bool, error) {
+	return IsAnimatedContext(ctx, r, opts)
+}
+
+func (Format) RenderFirstFrame(ctx context.Context, r io.Reader, w io.Writer, opts *deanimator.Options) (*deanimator.Result, error) {
+	return RenderFirstFrameContext(ctx, r, w, opts)
+}
+
+func (Format) FirstFrame(ctx context.Context, r io.Reader, opts *deanimator.Options) (image.Image, error) {
+	return FirstFrameContext(ctx, r, opts)
+}
+
+func (Format) RenderFrame(ctx context.Context, r io.Reader, w io.Writer, index int, opts *deanimator.Options) error {
+	return RenderFrameContext(ctx, r, w, index, opts)
+}
+
+func (Format) WalkFrames(ctx context.Context, r io.Reader, opts *deanimator.Options, fn func(m image.Image) error) error {
+	return WalkFramesContext(ctx, r, opts, fn)
+}
+
+func (Format) VerifyAnimated(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Detection, error) {
+	return VerifyAnimated(ctx, r, opts)
+}
+
+func (Format) Inspect(ctx context.Context, r io.Reader, opts *deanimator.Options) (*deanimator.Info, error) {
+	return Inspect(ctx, r, opts)
+}
+
+func (Format) NewIncrementalDetector(opts *deanimator.Options) deanimator.IncrementalDetector {
+	return NewIncrementalDetector(opts)
+}
+
+func (Format) Validate(ctx context.Context, r io.Reader, opts *deanimator.Options) error {
+	return Validate(ctx, r, opts)
+}
+
 func init() {
-	deanimator.RegisterFormat("webp", "RIFF????WEBPVP8", IsAnimated, RenderFirstFrame)
+	deanimator.Register(Format{})
 }
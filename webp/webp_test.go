@@ -2,15 +2,26 @@ package webp
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	gowebp "golang.org/x/image/webp"
 
+	"github.com/slackhq/deanimator"
 	"github.com/slackhq/deanimator/goldentest"
+	"github.com/slackhq/deanimator/internal/fuzztest"
 )
 
 var (
@@ -89,8 +100,7 @@ func TestIsAnimated(t *testing.T) {
 			} else if err != nil {
 				if !expectUnderflowError {
 					t.Errorf("%d: expected no error, got %v", idx, err)
-				} else if err.Error() != "riff: short chunk header" && err != io.EOF {
-					// this probably shouldn't be hardcoded, but just for testing...
+				} else if !errors.Is(err, deanimator.ErrTruncated) {
 					t.Errorf("%d: expected underflow error, got %v", idx, err)
 				}
 			}
@@ -183,3 +193,570 @@ func TestRenderFirstFrameMinimumDownload(t *testing.T) {
 		t.Fatalf("expected bounds (0,0)-(400,400), got %s", bounds)
 	}
 }
+
+func TestContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := IsAnimatedContext(ctx, bytes.NewReader(animatedWEBP), nil); err != context.Canceled {
+		t.Errorf("expected IsAnimatedContext to return context.Canceled, got %v", err)
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	if _, err := RenderFirstFrameContext(ctx, bytes.NewReader(animatedWEBP), w, nil); err != context.Canceled {
+		t.Errorf("expected RenderFirstFrameContext to return context.Canceled, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, data := range [][]byte{animatedWEBP, regularWEBP, losslessWEBP, lossyAlphaWEBP} {
+		if err := Validate(context.Background(), bytes.NewReader(data), nil); err != nil {
+			t.Errorf("expected a valid webp, got %v", err)
+		}
+	}
+
+	if err := Validate(context.Background(), bytes.NewReader(animatedWEBP[:5234]), nil); err == nil {
+		t.Errorf("expected a truncated webp to be invalid")
+	}
+}
+
+func TestRenderFirstFrameErrors(t *testing.T) {
+	for _, data := range [][]byte{regularWEBP, losslessWEBP, lossyAlphaWEBP} {
+		w := bytes.NewBuffer([]byte{})
+		if err := RenderFirstFrame(bytes.NewReader(data), w); err != deanimator.ErrNotAnimated {
+			t.Errorf("expected ErrNotAnimated, got %v", err)
+		}
+	}
+
+	w := bytes.NewBuffer([]byte{})
+	err := RenderFirstFrame(bytes.NewReader(animatedWEBP[:4728]), w)
+	if !errors.Is(err, deanimator.ErrTruncated) {
+		t.Errorf("expected truncated error, got %v", err)
+	}
+	var perr *deanimator.ParseError
+	if !errors.As(err, &perr) || perr.Format != "webp" || perr.Chunk != "ANMF" || perr.Offset != 44 {
+		t.Errorf("expected a webp parse error for the ANMF chunk at offset 44, got %v", err)
+	}
+}
+
+func TestRenderFirstFrameMetadata(t *testing.T) {
+	expected := bytes.NewBuffer([]byte{})
+	if err := RenderFirstFrame(bytes.NewReader(animatedWEBP), expected); err != nil {
+		t.Fatal(err)
+	}
+	// metadata before the first frame is passed over
+	w := bytes.NewBuffer([]byte{})
+	if err := RenderFirstFrame(bytes.NewReader(withEXIFChunk(animatedWEBP, 101)), w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.Bytes(), expected.Bytes()) {
+		t.Errorf("expected the same first frame with an EXIF chunk before it")
+	}
+}
+
+func TestRenderFirstFrameResult(t *testing.T) {
+	w := bytes.NewBuffer([]byte{})
+	res, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(animatedWEBP), w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := deanimator.Result{
+		InputFormat:  "webp",
+		OutputFormat: "webp",
+		MIMEType:     "image/webp",
+		Width:        400,
+		Height:       400,
+	}
+	if *res != expected {
+		t.Errorf("expected result %+v, got %+v", expected, *res)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, tc := range []struct {
+		limits      deanimator.Limits
+		expectLimit string
+	}{
+		{deanimator.Limits{MaxPixels: 400 * 400, MaxBytes: int64(len(animatedWEBP)), MaxChunkSize: 1 << 20}, ""},
+		{deanimator.Limits{MaxPixels: 400*400 - 1}, "MaxPixels"},
+		{deanimator.Limits{MaxBytes: 1000}, "MaxBytes"},
+		{deanimator.Limits{MaxChunkSize: 100}, "MaxChunkSize"},
+	} {
+		t.Run(fmt.Sprintf("%+v", tc.limits), func(t *testing.T) {
+			w := bytes.NewBuffer([]byte{})
+			_, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(animatedWEBP), w, &deanimator.Options{Limits: &tc.limits})
+			checkLimitError(t, err, tc.expectLimit)
+		})
+	}
+
+	limits := &deanimator.Limits{MaxFrames: 1}
+	err := Validate(context.Background(), bytes.NewReader(animatedWEBP), &deanimator.Options{Limits: limits})
+	checkLimitError(t, err, "MaxFrames")
+}
+
+func checkLimitError(t *testing.T, err error, expectLimit string) {
+	t.Helper()
+	if expectLimit == "" {
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		return
+	}
+	var lerr *deanimator.LimitError
+	if !errors.Is(err, deanimator.ErrLimitExceeded) || !errors.As(err, &lerr) || lerr.Limit != expectLimit {
+		t.Errorf("expected %s to be exceeded, got %v", expectLimit, err)
+	}
+}
+
+// FuzzFormat checks that no input makes any capability of the webp format panic, and that its
+// incremental detector agrees with VerifyAnimated.
+func FuzzFormat(f *testing.F) {
+	fuzztest.Fuzz(f, Format{}, animatedWEBP, regularWEBP, losslessWEBP, lossyAlphaWEBP)
+}
+
+func TestFirstFrame(t *testing.T) {
+	golden, err := ioutil.ReadFile("../testdata/animated_golden.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := gowebp.Decode(bytes.NewReader(golden))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := FirstFrame(bytes.NewReader(animatedWEBP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected the first frame to match the rendered golden file")
+	}
+
+	for _, data := range [][]byte{regularWEBP, losslessWEBP, lossyAlphaWEBP} {
+		m, err := FirstFrame(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := gowebp.Decode(bytes.NewReader(data))
+		if !reflect.DeepEqual(m, expected) {
+			t.Errorf("expected a still webp to be its own first frame")
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	info, err := Inspect(context.Background(), bytes.NewReader(animatedWEBP), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 400 || info.Height != 400 || info.Frames != 12 || info.LoopCount != 0 || !info.HasAlpha {
+		t.Errorf("expected 12 frames of 400x400 looping forever with alpha, got %+v", *info)
+	}
+	if len(info.Delays) != 12 || info.Delays[0] != 70*time.Millisecond || info.Duration != 840*time.Millisecond {
+		t.Errorf("expected 12 delays of 70ms lasting 840ms, got %v lasting %v", info.Delays, info.Duration)
+	}
+
+	for _, tc := range []struct {
+		data     []byte
+		expected deanimator.Info
+	}{
+		{regularWEBP, deanimator.Info{Format: "webp", Width: 1536, Height: 1024, Frames: 1, LoopCount: 1}},
+		{losslessWEBP, deanimator.Info{Format: "webp", Width: 400, Height: 301, Frames: 1, LoopCount: 1, HasAlpha: true}},
+		{lossyAlphaWEBP, deanimator.Info{Format: "webp", Width: 400, Height: 301, Frames: 1, LoopCount: 1, HasAlpha: true}},
+	} {
+		info, err := Inspect(context.Background(), bytes.NewReader(tc.data), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*info, tc.expected) {
+			t.Errorf("expected info %+v, got %+v", tc.expected, *info)
+		}
+	}
+}
+
+// riffChunk returns a RIFF chunk of the given FourCC and data, padded to an even length.
+func riffChunk(fourCC string, data []byte) []byte {
+	b := make([]byte, 8, 8+len(data)+1)
+	copy(b, fourCC)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestVerifyAnimated(t *testing.T) {
+	vp8x := riffChunk("VP8X", []byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	oneFrame := riffChunk("RIFF", append([]byte("WEBP"), bytes.Join([][]byte{
+		vp8x,
+		riffChunk("ANIM", make([]byte, 6)),
+		riffChunk("ANMF", make([]byte, 16)),
+	}, nil)...))
+
+	for _, tc := range []struct {
+		name         string
+		data         []byte
+		expect       deanimator.State
+		expectReason string
+	}{
+		{"animated", animatedWEBP, deanimator.Animated, "VP8X animation flag set and a second ANMF frame follows"},
+		{"lossy", regularWEBP, deanimator.Static, "simple format VP8 image"},
+		{"lossless", losslessWEBP, deanimator.Static, "simple format VP8L image"},
+		{"extended", lossyAlphaWEBP, deanimator.Static, "VP8X animation flag not set"},
+		{"one frame", oneFrame, deanimator.Static, "VP8X animation flag set but only 1 ANMF frame(s)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := VerifyAnimated(context.Background(), bytes.NewReader(tc.data), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.State != tc.expect || d.Reason != tc.expectReason {
+				t.Errorf("expected %v because %q, got %v because %q", tc.expect, tc.expectReason, d.State, d.Reason)
+			}
+
+			// the incremental detector must agree when the data arrives a byte at a time
+			inc := NewIncrementalDetector(nil)
+			var pushed *deanimator.Detection
+			for i := 0; i < len(tc.data) && pushed == nil; i++ {
+				if pushed, err = inc.Write(tc.data[i : i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if pushed == nil || pushed.State != d.State || pushed.Reason != d.Reason {
+				t.Errorf("expected the incremental detector to agree with %+v, got %+v", *d, pushed)
+			}
+		})
+	}
+}
+
+// u24le returns v as a 24 bit little-endian integer.
+func u24le(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// offsetWEBP returns an animated WebP of two copies of the first frame of animated.webp, the first
+// at x, y on a canvas of width by height with a red background.
+func offsetWEBP(t *testing.T, x, y, width, height int) []byte {
+	t.Helper()
+	golden, err := ioutil.ReadFile("../testdata/animated_golden.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the "VP8L" chunk of a 400x400 frame follows the "VP8X" chunk
+	bitstream := golden[12+18:]
+	anmf := func(x, y int) []byte {
+		header := bytes.Join([][]byte{u24le(x / 2), u24le(y / 2), u24le(400 - 1), u24le(400 - 1), u24le(100), {0}}, nil)
+		return riffChunk("ANMF", append(header, bitstream...))
+	}
+	vp8x := append([]byte{2 | 16, 0, 0, 0}, append(u24le(width-1), u24le(height-1)...)...)
+	return riffChunk("RIFF", append([]byte("WEBP"), bytes.Join([][]byte{
+		riffChunk("VP8X", vp8x),
+		riffChunk("ANIM", []byte{0, 0, 0xff, 0xff, 0, 0}),
+		anmf(x, y),
+		anmf(0, 0),
+	}, nil)...))
+}
+
+func TestFirstFrameOffset(t *testing.T) {
+	data := offsetWEBP(t, 50, 20, 500, 450)
+	frame, err := FirstFrame(bytes.NewReader(animatedWEBP))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a frame that does not cover the canvas is drawn onto it, and written as a png
+	w := bytes.NewBuffer([]byte{})
+	res, err := RenderFirstFrameContext(context.Background(), bytes.NewReader(data), w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OutputFormat != "png" || res.MIMEType != "image/png" || res.Width != 500 || res.Height != 450 {
+		t.Errorf("expected a 500x450 png result, got %+v", *res)
+	}
+	rendered, err := png.Decode(w)
+	if err != nil {
+		t.Fatalf("expected a valid png, got %v", err)
+	}
+	composited, err := FirstFrame(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rendered.Bounds(), composited.Bounds()) ||
+		color.NRGBAModel.Convert(rendered.At(150, 120)) != color.NRGBAModel.Convert(composited.At(150, 120)) {
+		t.Errorf("expected the png to be the frame drawn onto the canvas")
+	}
+
+	// the decoded frame is drawn onto the canvas
+	for _, tc := range []struct {
+		fill         bool
+		expectCanvas color.NRGBA
+	}{
+		{false, color.NRGBA{}},
+		{true, color.NRGBA{R: 0xff, A: 0xff}},
+	} {
+		opts := &deanimator.Options{FormatOptions: map[string]interface{}{"webp": &Options{FillBackground: tc.fill}}}
+		m, err := FirstFrameContext(context.Background(), bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Bounds() != image.Rect(0, 0, 500, 450) {
+			t.Fatalf("expected the bounds of the canvas, got %v", m.Bounds())
+		}
+		if c := color.NRGBAModel.Convert(m.At(10, 10)); c != tc.expectCanvas {
+			t.Errorf("fill %v: expected %v outside of the frame, got %v", tc.fill, tc.expectCanvas, c)
+		}
+		expect := color.NRGBAModel.Convert(frame.At(100, 100))
+		if c := color.NRGBAModel.Convert(m.At(150, 120)); c != expect {
+			t.Errorf("fill %v: expected the frame at its offset, got %v instead of %v", tc.fill, c, expect)
+		}
+	}
+
+	cfg, err := FirstFrameConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 500 || cfg.Height != 450 {
+		t.Errorf("expected the size of the canvas, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// a frame must lie within the canvas
+	_, err = FirstFrame(bytes.NewReader(offsetWEBP(t, 200, 0, 500, 450)))
+	if !errors.Is(err, deanimator.ErrMalformed) {
+		t.Errorf("expected malformed error, got %v", err)
+	}
+}
+
+func TestRenderFrame(t *testing.T) {
+	data := offsetWEBP(t, 50, 20, 500, 450)
+	frame, err := FirstFrame(bytes.NewReader(animatedWEBP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(x, y int) color.Color {
+		return color.NRGBAModel.Convert(frame.At(x, y))
+	}
+
+	for _, tc := range []struct {
+		index  int
+		fill   bool
+		expect map[image.Point]color.Color
+	}{
+		{0, false, map[image.Point]color.Color{{10, 10}: color.NRGBA{}, {150, 120}: at(100, 100)}},
+		{0, true, map[image.Point]color.Color{{10, 10}: color.NRGBA{R: 0xff, A: 0xff}, {150, 120}: at(100, 100)}},
+		// the second frame covers the top left of the first
+		{1, false, map[image.Point]color.Color{{100, 100}: at(100, 100), {449, 419}: at(399, 399), {499, 449}: color.NRGBA{}}},
+	} {
+		opts := &deanimator.Options{FormatOptions: map[string]interface{}{"webp": &Options{FillBackground: tc.fill}}}
+		w := bytes.NewBuffer([]byte{})
+		if err := RenderFrameContext(context.Background(), bytes.NewReader(data), w, tc.index, opts); err != nil {
+			t.Fatal(err)
+		}
+		m, err := png.Decode(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Bounds() != image.Rect(0, 0, 500, 450) {
+			t.Fatalf("expected the bounds of the canvas, got %v", m.Bounds())
+		}
+		for p, c := range tc.expect {
+			if got := color.NRGBAModel.Convert(m.At(p.X, p.Y)); got != c {
+				t.Errorf("frame %d, fill %v: expected %v at %v, got %v", tc.index, tc.fill, c, p, got)
+			}
+		}
+	}
+
+	if err := RenderFrame(bytes.NewReader(data), io.Discard, 2); !errors.Is(err, deanimator.ErrFrameIndex) {
+		t.Errorf("expected ErrFrameIndex past the last frame, got %v", err)
+	}
+
+	// a still image is its only frame
+	if err := RenderFrame(bytes.NewReader(regularWEBP), io.Discard, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderFrame(bytes.NewReader(regularWEBP), io.Discard, 1); !errors.Is(err, deanimator.ErrFrameIndex) {
+		t.Errorf("expected ErrFrameIndex past the still image, got %v", err)
+	}
+}
+
+func TestWalkFrames(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/animated.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := 0
+	err = WalkFrames(bytes.NewReader(data), func(m image.Image) error {
+		frames++
+		return nil
+	})
+	if err != nil || frames != 12 {
+		t.Errorf("expected 12 frames, got %d, %v", frames, err)
+	}
+
+	// an error returned by fn stops the walk
+	stop := errors.New("stop")
+	frames = 0
+	err = WalkFrames(bytes.NewReader(data), func(m image.Image) error {
+		frames++
+		return stop
+	})
+	if err != stop || frames != 1 {
+		t.Errorf("expected the walk to stop after 1 frame, got %d, %v", frames, err)
+	}
+}
+
+// TestChunkErrors checks that input ending early within the RIFF structure is told apart from a
+// malformed one.
+func TestChunkErrors(t *testing.T) {
+	// riffHeader returns the header of a RIFF chunk of size bytes of data, starting with "WEBP"
+	riffHeader := func(size int) []byte {
+		b := []byte("RIFF\x00\x00\x00\x00WEBP")
+		binary.LittleEndian.PutUint32(b[4:], uint32(size))
+		return b
+	}
+	vp8 := func(length int, data []byte) []byte {
+		b := []byte("VP8 \x00\x00\x00\x00")
+		binary.LittleEndian.PutUint32(b[4:], uint32(length))
+		return append(b, data...)
+	}
+
+	for _, tc := range []struct {
+		name, message string
+		data          []byte
+		expect        error
+	}{
+		{"truncated header", "short RIFF header", []byte("RIF"), deanimator.ErrNeedMoreData},
+		{"not riff", "missing RIFF header", []byte("RIFX\x04\x00\x00\x00WEBP"), deanimator.ErrMalformed},
+		{"truncated chunk header", "short chunk header: deanimator: need at least 4", append(riffHeader(4+8), "VP8 "...), deanimator.ErrNeedMoreData},
+		{"short chunk header", "short chunk header", append(riffHeader(4+4), "VP8 "...), deanimator.ErrMalformed},
+		{"truncated chunk data", "short chunk data: deanimator: need at least 8", append(riffHeader(4+8+10), vp8(10, []byte{0, 0})...), deanimator.ErrNeedMoreData},
+		{"truncated padding", "short padding byte", append(riffHeader(4+8+2), vp8(1, []byte{0})...), deanimator.ErrNeedMoreData},
+		{"chunk too long", "chunk too long", append(riffHeader(4+8+2), vp8(100, []byte{0, 0})...), deanimator.ErrMalformed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, r := range []io.Reader{bytes.NewReader(tc.data), struct{ io.Reader }{bytes.NewReader(tc.data)}} {
+				err := Validate(context.Background(), r, nil)
+				if !errors.Is(err, tc.expect) {
+					t.Errorf("%T: expected %v, got %v", r, tc.expect, err)
+				}
+				if err == nil || !strings.Contains(err.Error(), tc.message) {
+					t.Errorf("%T: expected the error %q, got %v", r, tc.message, err)
+				}
+			}
+		})
+	}
+}
+
+// withEXIFChunk returns data with an "EXIF" chunk of size bytes inserted after its "VP8X" chunk.
+func withEXIFChunk(data []byte, size int) []byte {
+	const afterVP8X = 12 + 8 + 10
+	b := append([]byte{}, data[:afterVP8X]...)
+	b = append(b, riffChunk("EXIF", make([]byte, size))...)
+	b = append(b, data[afterVP8X:]...)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+// seekingReader counts the bytes read from a bytes.Reader.
+type seekingReader struct {
+	*bytes.Reader
+	read int64
+}
+
+func (r *seekingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+func TestSeek(t *testing.T) {
+	data := withEXIFChunk(animatedWEBP, 1<<20+1)
+	unskipped := int64(len(data) - (1<<20 + 1))
+
+	r := &seekingReader{Reader: bytes.NewReader(data)}
+	info, err := Inspect(context.Background(), r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.read > unskipped {
+		t.Errorf("expected the EXIF chunk to be seeked over, read %d bytes", r.read)
+	}
+	expected, err := Inspect(context.Background(), struct{ io.Reader }{bytes.NewReader(data)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected the same info when seeking, got %+v and %+v", *info, *expected)
+	}
+
+	r = &seekingReader{Reader: bytes.NewReader(data)}
+	if err := Validate(context.Background(), r, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.read > unskipped {
+		t.Errorf("expected the EXIF chunk to be seeked over, read %d bytes", r.read)
+	}
+
+	r = &seekingReader{Reader: bytes.NewReader(data)}
+	frames := 0
+	err = WalkFrames(r, func(m image.Image) error {
+		frames++
+		return nil
+	})
+	if err != nil || frames != 12 {
+		t.Errorf("expected 12 frames, got %d, %v", frames, err)
+	}
+	if r.read > unskipped {
+		t.Errorf("expected the EXIF chunk to be seeked over, read %d bytes", r.read)
+	}
+
+	// truncated within the EXIF chunk
+	for _, r := range []io.Reader{bytes.NewReader(data[:1000]), struct{ io.Reader }{bytes.NewReader(data[:1000])}} {
+		if _, err := Inspect(context.Background(), r, nil); !errors.Is(err, deanimator.ErrNeedMoreData) {
+			t.Errorf("%T: expected ErrNeedMoreData, got %v", r, err)
+		}
+	}
+
+	opts := &deanimator.Options{Limits: &deanimator.Limits{MaxBytes: 1 << 19}}
+	_, err = Inspect(context.Background(), bytes.NewReader(data), opts)
+	checkLimitError(t, err, "MaxBytes")
+}
+
+// BenchmarkVerifyAnimated walks the chunks up to the second frame, unlike IsAnimated, which only
+// reads the first chunk.
+func BenchmarkVerifyAnimated(b *testing.B) {
+	data := withEXIFChunk(animatedWEBP, 16<<20)
+	for _, bc := range []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{"Seeker", func() io.Reader { return bytes.NewReader(data) }},
+		{"Reader", func() io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := VerifyAnimated(context.Background(), bc.reader(), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRenderFirstFrame(b *testing.B) {
+	data := withEXIFChunk(animatedWEBP, 16<<20)
+	for _, bc := range []struct {
+		name   string
+		reader func() io.Reader
+	}{
+		{"Seeker", func() io.Reader { return bytes.NewReader(data) }},
+		{"Reader", func() io.Reader { return struct{ io.Reader }{bytes.NewReader(data)} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := RenderFirstFrame(bc.reader(), io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,80 +2,230 @@
 package window
 
 import (
+	"bytes"
 	"errors"
 	"io"
 )
 
+// minBufferSize is the least number of bytes a WindowedReader buffers.
+const minBufferSize = 4096
+
+var (
+	errWindowLength = errors.New("window is incorrect length")
+	errPattern      = errors.New("pattern is empty or longer than the window")
+)
+
+// A WindowedReader slides a window of a fixed size over the data of a reader, one byte at a time.
+// It reads the data in large pieces into a buffer allocated once, so reading windows, skipping and
+// scanning do not allocate.
 type WindowedReader struct {
 	r          io.Reader
 	windowSize int
 
-	previous []byte
+	buf        []byte
+	start, end int // the window starts at buf[start], buf[start:end] is buffered
+	started    bool
+	err        error // sticky error of r
 }
 
+// NewReader returns a WindowedReader of windows of size bytes over r.
 func NewReader(r io.Reader, size int) *WindowedReader {
+	n := 2 * size
+	if n < minBufferSize {
+		n = minBufferSize
+	}
 	return &WindowedReader{
 		r:          r,
 		windowSize: size,
+		buf:        make([]byte, n),
 	}
 }
 
+// Skip advances the window size times, the first of which reads the first window. It returns io.EOF
+// if the data ended before the first window. If the data ends later it returns how many windows it
+// advanced plus one, and no error.
 func (w *WindowedReader) Skip(size int) (int, error) {
-	// TODO: optimize this by just ignoring anything that wouldn't be in the last window?
-	discard := make([]byte, w.windowSize)
-	for i := 0; i < size; i++ {
-		_, err := w.ReadWindow(discard)
-		if err == io.EOF {
-			if i == 0 {
-				// if its a re-read and an EOF, just return EOF
-				return 0, err
-			}
-			// if its the first encounter of the EOF, just return successful skip count
-			return i + 1, nil
-		} else if err != nil {
-			return i, err
+	if size <= 0 {
+		return size, nil
+	}
+	skipped := 0
+	if !w.started {
+		if n, err := w.first(); n == 0 {
+			return 0, err
+		}
+		skipped++
+	}
+	moved, err := w.advance(size - skipped)
+	skipped += moved
+	if err == io.EOF {
+		if skipped == 0 {
+			return 0, err
 		}
+		// if its the first encounter of the EOF, just return successful skip count
+		return skipped + 1, nil
 	}
-	return size, nil
+	return skipped, err
 }
 
 // ReadWindow returns the current window of bytes. Each successive call to ReadWindow
 // only advances the reader a single byte.
 func (w *WindowedReader) ReadWindow(window []byte) (int, error) {
 	if len(window) != w.windowSize {
-		return 0, errors.New("window is incorrect length")
+		return 0, errWindowLength
 	}
 
-	firstRead := w.previous == nil
-
-	if firstRead {
-		previous := make([]byte, w.windowSize-1)
-		read, err := w.r.Read(previous)
-		if err != nil {
+	if !w.started {
+		n, err := w.first()
+		if n == 0 {
 			return 0, err
-		} else if read < len(previous) {
-			copy(window, previous[:read])
-			return read, nil
 		}
-		w.previous = previous
+		// the data may be shorter than a window
+		return copy(window, w.buf[w.start:w.start+n]), nil
 	}
 
-	next := make([]byte, 1)
-	_, err := w.r.Read(next)
-	if err == io.EOF && firstRead {
-		// if the full length is the same size as the previous window, just return that
-		copy(window, w.previous)
-		return len(w.previous), nil
-	} else if err != nil {
+	if w.end-w.start > w.windowSize {
+		w.start++
+	} else if moved, err := w.advance(1); moved == 0 {
 		return 0, err
 	}
+	return copy(window, w.buf[w.start:w.start+w.windowSize]), nil
+}
 
-	// copy to output window
-	copy(window, w.previous)
-	window[w.windowSize-1] = next[0]
+// Scan advances the window until it starts with one of patterns, and returns the index of the first
+// such pattern. The current window is matched too, so calling Scan again returns the same match
+// until the window advances. The patterns must not be longer than the window. If the data ends
+// before a match, Scan returns io.EOF.
+func (w *WindowedReader) Scan(patterns ...[]byte) (int, error) {
+	var first [256]bool
+	for _, p := range patterns {
+		if len(p) == 0 || len(p) > w.windowSize {
+			return -1, errPattern
+		}
+		first[p[0]] = true
+	}
 
-	// update previous read
-	w.previous = append(w.previous[1:], next[0])
+	if !w.started {
+		n, err := w.first()
+		if n == 0 {
+			return -1, err
+		} else if n < w.windowSize {
+			// the data is shorter than a window
+			if i := match(w.buf[w.start:w.end], patterns); i >= 0 {
+				return i, nil
+			}
+			return -1, io.EOF
+		}
+	}
+	for {
+		for ; w.start+w.windowSize <= w.end; w.start++ {
+			if !first[w.buf[w.start]] {
+				continue
+			}
+			if i := match(w.buf[w.start:w.start+w.windowSize], patterns); i >= 0 {
+				return i, nil
+			}
+		}
+		// the window at w.start has not been read in full yet
+		if err := w.fill(); err != nil {
+			return -1, err
+		}
+	}
+}
 
-	return w.windowSize, nil
+// match returns the index of the first of patterns that b starts with, or -1.
+func match(b []byte, patterns [][]byte) int {
+	for i, p := range patterns {
+		if bytes.HasPrefix(b, p) {
+			return i
+		}
+	}
+	return -1
+}
+
+// first reads the first window, and returns how many bytes of it the data has.
+func (w *WindowedReader) first() (int, error) {
+	w.started = true
+	for w.end-w.start < w.windowSize {
+		if err := w.fill(); err != nil {
+			if err != io.EOF {
+				return 0, err
+			}
+			break
+		}
+	}
+	return w.end - w.start, w.err
+}
+
+// advance moves the window k bytes forward, and returns how far it moved before the data ended,
+// with the error that ended it.
+func (w *WindowedReader) advance(k int) (int, error) {
+	moved := 0
+	for moved < k {
+		if buffered := w.end - w.start - w.windowSize; buffered > 0 {
+			n := k - moved
+			if n > buffered {
+				n = buffered
+			}
+			w.start += n
+			moved += n
+			continue
+		}
+
+		if rest := k - moved; rest > len(w.buf) && w.err == nil {
+			// skip the bytes before the last window without buffering them
+			had := w.end - w.start
+			discarded, err := io.CopyN(io.Discard, w.r, int64(rest-had))
+			w.start, w.end = 0, 0
+			if err != nil {
+				w.err = err
+				if valid := had + int(discarded) - w.windowSize; valid > 0 {
+					moved += valid
+				}
+				return moved, err
+			}
+			for w.end < w.windowSize {
+				if err := w.fill(); err != nil {
+					if valid := rest + w.end - w.windowSize; valid > 0 {
+						moved += valid
+					}
+					return moved, err
+				}
+			}
+			moved += rest
+			continue
+		}
+
+		if err := w.fill(); err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// fill reads more data into the buffer, keeping the bytes from the start of the window.
+func (w *WindowedReader) fill() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.start > 0 {
+		w.end = copy(w.buf, w.buf[w.start:w.end])
+		w.start = 0
+	}
+	// readers may return no bytes and no error, give them a few tries
+	for i := 0; i < 100; i++ {
+		n, err := w.r.Read(w.buf[w.end:])
+		w.end += n
+		if err != nil {
+			w.err = err
+			if n > 0 {
+				return nil
+			}
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+	w.err = io.ErrNoProgress
+	return w.err
 }
@@ -2,8 +2,11 @@ package window
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"math/rand"
 	"testing"
+	"testing/iotest"
 )
 
 func TestWindowedReader(t *testing.T) {
@@ -162,3 +165,183 @@ func TestWindowedReader_emptyReader(t *testing.T) {
 		t.Fatalf("expected skipped to be 0, got: %d", skipped)
 	}
 }
+
+func TestWindowedReader_scan(t *testing.T) {
+	wr := NewReader(bytes.NewReader([]byte("0123456789ABCDE")), 3)
+
+	i, err := wr.Scan([]byte("9A"), []byte("56"), []byte("5"))
+	if err != nil || i != 1 {
+		t.Fatalf("expected pattern 1, got %d, %v", i, err)
+	}
+	// the window is not advanced past a match
+	i, err = wr.Scan([]byte("5"))
+	if err != nil || i != 0 {
+		t.Fatalf("expected pattern 0, got %d, %v", i, err)
+	}
+
+	data := make([]byte, 3)
+	if read, err := wr.ReadWindow(data); err != nil || string(data[:read]) != "678" {
+		t.Fatalf("expected data to be \"678\", got %q, %v", data[:read], err)
+	}
+
+	if _, err := wr.Scan([]byte("0123")); err == nil {
+		t.Fatalf("expected error, pattern longer than the window")
+	}
+	if i, err := wr.Scan([]byte("E")); err != io.EOF || i != -1 {
+		t.Fatalf("expected io.EOF, got %d, %v", i, err)
+	}
+
+	// data shorter than the window
+	wr = NewReader(bytes.NewReader([]byte("01")), 3)
+	if i, err := wr.Scan([]byte("x"), []byte("01")); err != nil || i != 1 {
+		t.Fatalf("expected pattern 1, got %d, %v", i, err)
+	}
+}
+
+// naiveReader is the unbuffered WindowedReader the buffered one replaced, to compare them.
+type naiveReader struct {
+	r          io.Reader
+	windowSize int
+
+	previous []byte
+}
+
+func (w *naiveReader) Skip(size int) (int, error) {
+	discard := make([]byte, w.windowSize)
+	for i := 0; i < size; i++ {
+		_, err := w.ReadWindow(discard)
+		if err == io.EOF {
+			if i == 0 {
+				return 0, err
+			}
+			return i + 1, nil
+		} else if err != nil {
+			return i, err
+		}
+	}
+	return size, nil
+}
+
+func (w *naiveReader) ReadWindow(window []byte) (int, error) {
+	firstRead := w.previous == nil
+
+	if firstRead {
+		previous := make([]byte, w.windowSize-1)
+		read, err := io.ReadFull(w.r, previous)
+		if err == io.ErrUnexpectedEOF {
+			copy(window, previous[:read])
+			return read, nil
+		} else if err != nil {
+			return 0, err
+		}
+		w.previous = previous
+	}
+
+	next := make([]byte, 1)
+	_, err := io.ReadFull(w.r, next)
+	if err == io.EOF && firstRead {
+		copy(window, w.previous)
+		return len(w.previous), nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	copy(window, w.previous)
+	window[w.windowSize-1] = next[0]
+	w.previous = append(w.previous[1:], next[0])
+
+	return w.windowSize, nil
+}
+
+func TestWindowedReader_naive(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 20000)
+	rnd.Read(data)
+
+	for i := 0; i < 200; i++ {
+		size := 2 + rnd.Intn(16)
+		length := rnd.Intn(len(data))
+		var r io.Reader = bytes.NewReader(data[:length])
+		if i%2 == 1 {
+			r = iotest.OneByteReader(r)
+		}
+		wr := NewReader(r, size)
+		naive := &naiveReader{r: bytes.NewReader(data[:length]), windowSize: size}
+
+		var ops []string
+		window, naiveWindow := make([]byte, size), make([]byte, size)
+		for j := 0; j < 50; j++ {
+			if rnd.Intn(2) == 0 {
+				n := rnd.Intn(3 * length / 50)
+				ops = append(ops, fmt.Sprintf("Skip(%d)", n))
+				skipped, err := wr.Skip(n)
+				naiveSkipped, naiveErr := naive.Skip(n)
+				if skipped != naiveSkipped || err != naiveErr {
+					t.Fatalf("size %d, length %d, %v: got %d, %v, expected %d, %v", size, length, ops, skipped, err, naiveSkipped, naiveErr)
+				}
+			} else {
+				ops = append(ops, "ReadWindow")
+				read, err := wr.ReadWindow(window)
+				naiveRead, naiveErr := naive.ReadWindow(naiveWindow)
+				if read != naiveRead || err != naiveErr || !bytes.Equal(window[:read], naiveWindow[:naiveRead]) {
+					t.Fatalf("size %d, length %d, %v: got %q, %v, expected %q, %v", size, length, ops, window[:read], err, naiveWindow[:naiveRead], naiveErr)
+				}
+			}
+		}
+	}
+}
+
+func TestWindowedReader_allocs(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1<<16)
+	wr := NewReader(bytes.NewReader(data), 8)
+	window := make([]byte, 8)
+	pattern := []byte("89")
+	allocs := testing.AllocsPerRun(100, func() {
+		wr.ReadWindow(window)
+		wr.Skip(5000)
+		wr.Scan(pattern)
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkReadWindow(b *testing.B) {
+	data := make([]byte, 1<<20)
+	window := make([]byte, 8)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		wr := NewReader(bytes.NewReader(data), len(window))
+		for {
+			if _, err := wr.ReadWindow(window); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkSkip(b *testing.B) {
+	data := make([]byte, 1<<20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		wr := NewReader(bytes.NewReader(data), 8)
+		for {
+			if _, err := wr.Skip(1000); err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	data := make([]byte, 1<<20)
+	copy(data[len(data)-8:], "IEND")
+	patterns := [][]byte{[]byte("acTL"), []byte("IDAT"), []byte("IEND")}
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		wr := NewReader(bytes.NewReader(data), 8)
+		if _, err := wr.Scan(patterns...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}